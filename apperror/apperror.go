@@ -0,0 +1,113 @@
+// Package apperror maps domain errors to the codes sent to clients in the "extensions.code" of GraphQL errors
+package apperror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type Code string
+
+const (
+	Unauthenticated Code = "UNAUTHENTICATED"
+	Forbidden       Code = "FORBIDDEN"
+	NotFound        Code = "NOT_FOUND"
+	Conflict        Code = "CONFLICT"
+	BadUserInput    Code = "BAD_USER_INPUT"
+	RateLimited     Code = "RATE_LIMITED"
+	Timeout         Code = "TIMEOUT"
+	Internal        Code = "INTERNAL"
+)
+
+// MongoDB error code for documents failing the collection schema validation
+const mongoDocumentValidationFailure = 121
+
+// Error is an error with a code, it implements gqlerrors.ExtendedError
+type Error struct {
+	Code    Code
+	Message string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" && e.Err != nil {
+		return e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Extensions() map[string]interface{} {
+	return map[string]interface{}{"code": string(e.Code)}
+}
+
+func New(code Code, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+func Newf(code Code, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// Wrap gives a code to an existing error, the message of the error is kept
+func Wrap(code Code, err error) *Error {
+	return &Error{Code: code, Err: err}
+}
+
+// From translates any error into an Error
+// Errors which already have a code are returned as is and the known database errors are mapped,
+// everything else is considered an internal error.
+func From(err error) *Error {
+
+	var appError *Error
+	if errors.As(err, &appError) {
+		return appError
+	}
+
+	switch {
+	case mongo.IsDuplicateKeyError(err):
+		return &Error{Code: Conflict, Message: "a document with the same key already exists", Err: err}
+
+	case errors.Is(err, mongo.ErrNoDocuments):
+		return &Error{Code: NotFound, Message: "not found", Err: err}
+
+	case isDocumentValidationError(err):
+		return &Error{Code: BadUserInput, Message: "document failed validation", Err: err}
+
+	case errors.Is(err, context.DeadlineExceeded):
+		return &Error{Code: Timeout, Message: "request timed out", Err: err}
+
+	case errors.Is(err, context.Canceled):
+		return &Error{Code: Internal, Message: "request canceled", Err: err}
+	}
+
+	return Wrap(Internal, err)
+}
+
+func isDocumentValidationError(err error) bool {
+	var writeException mongo.WriteException
+	if errors.As(err, &writeException) {
+		for _, writeError := range writeException.WriteErrors {
+			if writeError.Code == mongoDocumentValidationFailure {
+				return true
+			}
+		}
+	}
+
+	var bulkWriteException mongo.BulkWriteException
+	if errors.As(err, &bulkWriteException) {
+		for _, writeError := range bulkWriteException.WriteErrors {
+			if writeError.Code == mongoDocumentValidationFailure {
+				return true
+			}
+		}
+	}
+
+	return false
+}
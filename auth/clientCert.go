@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"go-graphql-mongo-server/config"
+	"go-graphql-mongo-server/logger"
+	"go-graphql-mongo-server/models"
+	"net/http"
+)
+
+// WithClientCertificate returns a copy of the request carrying its verified client certificate (mTLS), if any
+// The certificate is only used to authenticate requests without an Authorization header.
+func WithClientCertificate(r *http.Request) *http.Request {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), models.ClientCertContextKey, r.TLS.VerifiedChains[0][0]))
+}
+
+func getClientCertificate(ctx context.Context) *x509.Certificate {
+	certificate, _ := ctx.Value(models.ClientCertContextKey).(*x509.Certificate)
+	return certificate
+}
+
+// Returns the identity of a verified client certificate, which must be in the allow-list
+func authenticateClientCertificate(certificate *x509.Certificate) (Identity, error) {
+
+	for _, accepted := range config.Store.ClientCertIdentities {
+		if !isClientCertMatching(certificate, accepted) {
+			continue
+		}
+
+		identity := Identity{UserName: accepted.UserName, Scopes: accepted.Scopes, AuthMethod: models.AuthMethodMTLS}
+		if accepted.Service != "" {
+			// Services call the server as the internal user, as with service keys
+			identity.UserName = models.InternalUser
+			identity.Service = accepted.Service
+		}
+		if identity.UserName == "" {
+			logger.Log.Errorf("Client certificate identity %v %v has no userName or service", accepted.Subject, accepted.SAN)
+			return Identity{}, ErrUnauthenticated
+		}
+		return identity, nil
+	}
+
+	logger.Log.Errorf("Client certificate %v is not accepted", certificate.Subject)
+	return Identity{}, ErrUnauthenticated
+}
+
+// ValidateClientCertIdentities checks the identities of HTTPS_CLIENT_CERT_IDENTITIES, which must only have known scopes
+// and can't be mapped to one of the reserved user names. Services must have scopes, as an unscoped service would call
+// the server as the internal user with every permission.
+func ValidateClientCertIdentities() error {
+
+	for _, accepted := range config.Store.ClientCertIdentities {
+		if models.IsReservedUserName(accepted.UserName) {
+			return fmt.Errorf("client certificate identity %v %v uses the reserved user name %v", accepted.Subject, accepted.SAN, accepted.UserName)
+		}
+		if accepted.Service != "" && len(accepted.Scopes) == 0 {
+			return fmt.Errorf("client certificate identity %v %v of the service %v has no scopes", accepted.Subject, accepted.SAN, accepted.Service)
+		}
+		for _, scope := range accepted.Scopes {
+			if !models.IsPermission(scope) {
+				return fmt.Errorf("client certificate identity %v %v has the unknown scope %v", accepted.Subject, accepted.SAN, scope)
+			}
+		}
+	}
+	return nil
+}
+
+// Reports whether a certificate has the subject and the SAN of an accepted identity
+func isClientCertMatching(certificate *x509.Certificate, accepted config.ClientCertIdentity) bool {
+
+	if accepted.Subject == "" && accepted.SAN == "" {
+		return false
+	}
+
+	if accepted.Subject != "" && accepted.Subject != certificate.Subject.String() &&
+		accepted.Subject != "CN="+certificate.Subject.CommonName {
+		return false
+	}
+
+	if accepted.SAN != "" {
+		for _, san := range subjectAltNames(certificate) {
+			if san == accepted.SAN {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Returns the subject alternative names of a certificate, prefixed by their type (Eg. DNS:billing.internal)
+func subjectAltNames(certificate *x509.Certificate) []string {
+	var names []string
+	for _, name := range certificate.DNSNames {
+		names = append(names, "DNS:"+name)
+	}
+	for _, uri := range certificate.URIs {
+		names = append(names, "URI:"+uri.String())
+	}
+	for _, email := range certificate.EmailAddresses {
+		names = append(names, "EMAIL:"+email)
+	}
+	for _, ip := range certificate.IPAddresses {
+		names = append(names, "IP:"+ip.String())
+	}
+	return names
+}
@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"go-graphql-mongo-server/config"
+	"go-graphql-mongo-server/models"
+	"testing"
+)
+
+func TestValidateClientCertIdentities(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		identity config.ClientCertIdentity
+		wantErr  bool
+	}{
+		{
+			name:     "user",
+			identity: config.ClientCertIdentity{Subject: "CN=alice", UserName: "alice"},
+		},
+		{
+			name:     "scoped user",
+			identity: config.ClientCertIdentity{Subject: "CN=alice", UserName: "alice", Scopes: []string{models.PermissionUsersRead}},
+		},
+		{
+			name:     "scoped service",
+			identity: config.ClientCertIdentity{SAN: "DNS:billing.internal", Service: "billing", Scopes: []string{models.PermissionUsersRead}},
+		},
+		{
+			name:     "service without scopes",
+			identity: config.ClientCertIdentity{SAN: "DNS:billing.internal", Service: "billing"},
+			wantErr:  true,
+		},
+		{
+			name:     "service with empty scopes",
+			identity: config.ClientCertIdentity{SAN: "DNS:billing.internal", Service: "billing", Scopes: []string{}},
+			wantErr:  true,
+		},
+		{
+			name:     "unknown scope",
+			identity: config.ClientCertIdentity{Subject: "CN=alice", UserName: "alice", Scopes: []string{"users:*"}},
+			wantErr:  true,
+		},
+		{
+			name:     "internal user",
+			identity: config.ClientCertIdentity{Subject: "CN=root", UserName: models.InternalUser},
+			wantErr:  true,
+		},
+		{
+			name:     "guest user",
+			identity: config.ClientCertIdentity{Subject: "CN=guest", UserName: models.GuestUser},
+			wantErr:  true,
+		},
+	}
+
+	defer func() { config.Store.ClientCertIdentities = nil }()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config.Store.ClientCertIdentities = []config.ClientCertIdentity{test.identity}
+			if err := ValidateClientCertIdentities(); (err != nil) != test.wantErr {
+				t.Errorf("got error %v, want error %v", err, test.wantErr)
+			}
+		})
+	}
+}
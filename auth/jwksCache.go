@@ -0,0 +1,288 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-graphql-mongo-server/config"
+	"go-graphql-mongo-server/logger"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// Refresh interval of a JWKS without Cache-Control
+	defaultJWKSMaxAge = time.Hour
+
+	// Refresh interval and kid miss throttle used when JWKS_MIN_REFRESH_INTERVAL is invalid
+	defaultJWKSMinRefreshInterval = time.Minute
+
+	// Upper bound of the Cache-Control max-age honoured by the cache
+	maxJWKSMaxAge = 24 * time.Hour
+
+	// Bound of the negative cache, so that random kids can not grow it indefinitely
+	maxUnknownKids = 1000
+
+	jwksFetchTimeout = 10 * time.Second
+)
+
+var errUnknownKid = errors.New("unknown kid")
+
+var (
+	jwksRefreshFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "oidc_jwks_refresh_failures_total",
+		Help: "Number of failed refreshes of the public keys of an OIDC issuer",
+	}, []string{"issuer"})
+
+	jwksLastRefresh = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "oidc_jwks_last_refresh_timestamp_seconds",
+		Help: "Time of the last successful refresh of the public keys of an OIDC issuer",
+	}, []string{"issuer"})
+)
+
+// Public keys of an OIDC issuer at some point in time, a snapshot is never modified once it is published
+type jwksSnapshot struct {
+	keys      map[string]PublicKey
+	expiresAt time.Time
+}
+
+// The public keys of an OIDC issuer
+// Readers load the current snapshot without locking, refreshes publish a new snapshot atomically.
+// Refreshes triggered by unknown kids are merged and throttled, and the unknown kids are cached for a while.
+type jwksCache struct {
+	issuer   config.OIDCIssuer
+	snapshot atomic.Pointer[jwksSnapshot]
+	group    singleflight.Group
+
+	mutex       sync.Mutex
+	lastAttempt time.Time
+	unknownKids map[string]time.Time
+}
+
+// Caches of the trusted issuers by issuer URL
+var jwksCaches = struct {
+	sync.Mutex
+	caches map[string]*jwksCache
+}{caches: map[string]*jwksCache{}}
+
+// Returns the cache of a trusted issuer
+func getJWKSCache(issuer *config.OIDCIssuer) *jwksCache {
+	jwksCaches.Lock()
+	defer jwksCaches.Unlock()
+
+	cache, ok := jwksCaches.caches[issuer.Issuer]
+	if !ok {
+		cache = &jwksCache{issuer: *issuer, unknownKids: map[string]time.Time{}}
+		jwksCaches.caches[issuer.Issuer] = cache
+	}
+	return cache
+}
+
+// Returns the public key with a kid
+// An unknown kid refreshes the keys at most once per minimum refresh interval, as the issuer may have rotated its keys.
+func (cache *jwksCache) key(kid string) (PublicKey, error) {
+
+	if key, found := cache.lookup(kid); found {
+		return key, nil
+	}
+
+	now := time.Now()
+	cache.mutex.Lock()
+	unknownUntil, unknown := cache.unknownKids[kid]
+	cache.mutex.Unlock()
+
+	if unknown && now.Before(unknownUntil) {
+		return PublicKey{}, errUnknownKid
+	}
+
+	logger.Log.Infof("Unknown kid %v for the OIDC public keys of %v", kid, cache.issuer.Issuer)
+	cache.refreshThrottled()
+
+	if key, found := cache.lookup(kid); found {
+		return key, nil
+	}
+
+	cache.mutex.Lock()
+	if len(cache.unknownKids) >= maxUnknownKids {
+		cache.unknownKids = map[string]time.Time{}
+	}
+	cache.unknownKids[kid] = now.Add(jwksMinRefreshInterval())
+	cache.mutex.Unlock()
+
+	return PublicKey{}, errUnknownKid
+}
+
+func (cache *jwksCache) lookup(kid string) (PublicKey, bool) {
+	snapshot := cache.snapshot.Load()
+	if snapshot == nil {
+		return PublicKey{}, false
+	}
+	key, found := snapshot.keys[kid]
+	return key, found
+}
+
+// Reports whether the keys were retrieved at least once
+func (cache *jwksCache) isLoaded() bool {
+	return cache.snapshot.Load() != nil
+}
+
+// Reports whether the keys reached the max-age of their Cache-Control
+func (cache *jwksCache) isExpired() bool {
+	snapshot := cache.snapshot.Load()
+	return snapshot == nil || time.Now().After(snapshot.expiresAt)
+}
+
+// Retrieves the keys, concurrent refreshes share a single request
+// The current keys are kept when the refresh fails.
+func (cache *jwksCache) refresh() {
+	_, _, _ = cache.group.Do("refresh", func() (interface{}, error) {
+		cache.doRefresh()
+		return nil, nil
+	})
+}
+
+// Retrieves the keys unless they were retrieved during the minimum refresh interval
+// Callers wait for the refresh in progress, if any.
+func (cache *jwksCache) refreshThrottled() {
+	_, _, _ = cache.group.Do("refresh", func() (interface{}, error) {
+		cache.mutex.Lock()
+		throttled := time.Since(cache.lastAttempt) < jwksMinRefreshInterval()
+		cache.mutex.Unlock()
+
+		if !throttled {
+			cache.doRefresh()
+		}
+		return nil, nil
+	})
+}
+
+func (cache *jwksCache) doRefresh() {
+
+	cache.mutex.Lock()
+	cache.lastAttempt = time.Now()
+	cache.mutex.Unlock()
+
+	snapshot, err := cache.fetch()
+	if err != nil {
+		logger.Log.Errorf("Error in refreshing OIDC public keys of %v: %v", cache.issuer.Issuer, err)
+		jwksRefreshFailures.WithLabelValues(cache.issuer.Issuer).Inc()
+
+		// Retry at the next refresh, without dropping the current keys
+		if current := cache.snapshot.Load(); current != nil {
+			cache.snapshot.Store(&jwksSnapshot{keys: current.keys, expiresAt: time.Now().Add(jwksMinRefreshInterval())})
+		}
+		return
+	}
+
+	cache.snapshot.Store(snapshot)
+
+	cache.mutex.Lock()
+	cache.unknownKids = map[string]time.Time{}
+	cache.mutex.Unlock()
+
+	jwksLastRefresh.WithLabelValues(cache.issuer.Issuer).SetToCurrentTime()
+	logger.Log.Infof("Refreshed OIDC info of %v", cache.issuer.Issuer)
+}
+
+func (cache *jwksCache) fetch() (*jwksSnapshot, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), jwksFetchTimeout)
+	defer cancel()
+
+	jwksURI := cache.issuer.JwksURI
+	if jwksURI == "" {
+		body, _, err := retrieveFromURL(ctx, strings.TrimSuffix(cache.issuer.Issuer, "/")+"/.well-known/openid-configuration")
+		if err != nil {
+			return nil, err
+		}
+
+		var info OIDCInfo
+		err = json.Unmarshal(body, &info)
+		if err != nil {
+			return nil, fmt.Errorf("can not parse OIDC configuration info: %w", err)
+		}
+		if info.JwksURI == "" {
+			return nil, errors.New("OIDC configuration info has no jwks_uri")
+		}
+		jwksURI = info.JwksURI
+	}
+
+	body, header, err := retrieveFromURL(ctx, jwksURI)
+	if err != nil {
+		return nil, err
+	}
+
+	var publicKeys struct {
+		Keys []PublicKey `json:"keys"`
+	}
+	err = json.Unmarshal(body, &publicKeys)
+	if err != nil {
+		return nil, fmt.Errorf("can not parse OIDC public keys: %w", err)
+	}
+
+	snapshot := &jwksSnapshot{
+		keys:      make(map[string]PublicKey),
+		expiresAt: time.Now().Add(jwksMaxAge(header.Get("Cache-Control"))),
+	}
+	for _, key := range publicKeys.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+		key.Key, err = parseJWK(key)
+		if err != nil {
+			logger.Log.Errorf("Error in parsing OIDC public key %v of %v: %v", key.Kid, cache.issuer.Issuer, err)
+			continue
+		}
+		snapshot.keys[key.Kid] = key
+	}
+
+	if len(snapshot.keys) == 0 {
+		return nil, errors.New("no OIDC public keys found")
+	}
+	return snapshot, nil
+}
+
+// Returns how long a JWKS can be cached according to its Cache-Control header
+// The max-age is kept between the minimum refresh interval and a day.
+func jwksMaxAge(cacheControl string) time.Duration {
+
+	maxAge := defaultJWKSMaxAge
+	for _, directive := range strings.Split(cacheControl, ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+
+		switch {
+		case directive == "no-cache" || directive == "no-store":
+			return jwksMinRefreshInterval()
+		case strings.HasPrefix(directive, "max-age="):
+			seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+			if err == nil {
+				maxAge = time.Duration(seconds) * time.Second
+			}
+		}
+	}
+
+	if maxAge < jwksMinRefreshInterval() {
+		return jwksMinRefreshInterval()
+	}
+	if maxAge > maxJWKSMaxAge {
+		return maxJWKSMaxAge
+	}
+	return maxAge
+}
+
+func jwksMinRefreshInterval() time.Duration {
+	interval, err := time.ParseDuration(config.Store.JWKSMinRefreshInterval)
+	if err != nil || interval <= 0 {
+		logger.Log.Errorf("Invalid JWKS min refresh interval %v : %v", config.Store.JWKSMinRefreshInterval, err)
+		return defaultJWKSMinRefreshInterval
+	}
+	return interval
+}
@@ -0,0 +1,263 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"go-graphql-mongo-server/config"
+	"go-graphql-mongo-server/logger"
+	"math/big"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var errNoSigningKey = errors.New("no JWT signing key is configured")
+
+// A key used to sign and verify the in-house tokens
+type signingKey struct {
+	// RFC 7638 thumbprint of the public key
+	ID         string
+	Method     jwt.SigningMethod
+	PrivateKey crypto.Signer
+	PublicKey  crypto.PublicKey
+}
+
+// The in-house signing keys, new tokens are signed with the active key and verified with the key named by their kid
+type keyring struct {
+	keys   []*signingKey
+	byID   map[string]*signingKey
+	active *signingKey
+}
+
+// Parsed keyring, it is only parsed again when the configuration changes
+var keyringCache struct {
+	sync.Mutex
+	source      string
+	activeKeyID string
+	keyring     *keyring
+	err         error
+}
+
+// Returns the keyring built from config.Store.JWTInHousePrivateKey, which contains one or more PEM encoded keys
+// The active key is the one with the kid config.Store.JWTActiveKeyID, or the first key by default.
+func getKeyring() (*keyring, error) {
+	keyringCache.Lock()
+	defer keyringCache.Unlock()
+
+	source, activeKeyID := config.Store.JWTInHousePrivateKey, config.Store.JWTActiveKeyID
+	if keyringCache.keyring == nil && keyringCache.err == nil ||
+		keyringCache.source != source || keyringCache.activeKeyID != activeKeyID {
+
+		keyringCache.keyring, keyringCache.err = parseKeyring(source, activeKeyID)
+		keyringCache.source, keyringCache.activeKeyID = source, activeKeyID
+		if keyringCache.err != nil {
+			logger.Log.Errorf("Can not load JWT signing keys: %v", keyringCache.err)
+		}
+	}
+	return keyringCache.keyring, keyringCache.err
+}
+
+func parseKeyring(source string, activeKeyID string) (*keyring, error) {
+
+	ring := &keyring{byID: map[string]*signingKey{}}
+
+	rest := []byte(source)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+
+		key, err := parseSigningKey(block)
+		if err != nil {
+			return nil, err
+		}
+		if _, duplicate := ring.byID[key.ID]; duplicate {
+			continue
+		}
+
+		ring.keys = append(ring.keys, key)
+		ring.byID[key.ID] = key
+		logger.Log.Infof("Loaded JWT signing key %v (%v)", key.ID, key.Method.Alg())
+	}
+
+	if len(ring.keys) == 0 {
+		return nil, errNoSigningKey
+	}
+
+	ring.active = ring.keys[0]
+	if activeKeyID != "" {
+		active, ok := ring.byID[activeKeyID]
+		if !ok {
+			return nil, fmt.Errorf("active JWT signing key %v is not configured", activeKeyID)
+		}
+		ring.active = active
+	}
+
+	return ring, nil
+}
+
+// Parses a PKCS#1 RSA, SEC 1 EC or PKCS#8 (RSA, EC or Ed25519) private key
+func parseSigningKey(block *pem.Block) (*signingKey, error) {
+
+	var privateKey interface{}
+	var err error
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		privateKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		privateKey, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		privateKey, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %v", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can not parse %v: %w", block.Type, err)
+	}
+
+	key := &signingKey{}
+	switch privateKey := privateKey.(type) {
+	case *rsa.PrivateKey:
+		key.Method = jwt.SigningMethodRS512
+		key.PrivateKey = privateKey
+	case *ecdsa.PrivateKey:
+		switch privateKey.Curve {
+		case elliptic.P256():
+			key.Method = jwt.SigningMethodES256
+		case elliptic.P384():
+			key.Method = jwt.SigningMethodES384
+		case elliptic.P521():
+			key.Method = jwt.SigningMethodES512
+		default:
+			return nil, fmt.Errorf("unsupported EC curve %v", privateKey.Curve.Params().Name)
+		}
+		key.PrivateKey = privateKey
+	case ed25519.PrivateKey:
+		key.Method = jwt.SigningMethodEdDSA
+		key.PrivateKey = privateKey
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", privateKey)
+	}
+
+	key.PublicKey = key.PrivateKey.Public()
+	key.ID, err = jwkThumbprint(key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Returns the key which signed a token, using its kid header
+// Tokens issued before kids were added are checked against every key.
+func (ring *keyring) verificationKey(token *jwt.Token, tokenString string) (*signingKey, error) {
+
+	if kid, ok := token.Header["kid"]; ok {
+		kidString, _ := kid.(string)
+		key, found := ring.byID[kidString]
+		if !found {
+			return nil, fmt.Errorf("unknown kid %v", kid)
+		}
+		if token.Method.Alg() != key.Method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return key, nil
+	}
+
+	// The signature is only decoded by jwt.Parse once the key is known
+	separator := strings.LastIndexByte(tokenString, '.')
+	if separator < 0 {
+		return nil, errors.New("malformed token")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(tokenString[separator+1:])
+	if err != nil {
+		return nil, errors.New("malformed token signature")
+	}
+
+	for _, key := range ring.keys {
+		if token.Method.Alg() == key.Method.Alg() && token.Method.Verify(tokenString[:separator], signature, key.PublicKey) == nil {
+			return key, nil
+		}
+	}
+	return nil, errors.New("no key matches the token signature")
+}
+
+// Reports whether the kid of a token is one of the in-house keys
+func isSignedInHouse(token *jwt.Token) bool {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return false
+	}
+
+	ring, err := getKeyring()
+	if err != nil {
+		return false
+	}
+	_, found := ring.byID[kid]
+	return found
+}
+
+// Returns the members of the public JWK (RFC 7517) of a key
+func publicJWK(publicKey crypto.PublicKey) (map[string]string, error) {
+
+	encode := base64.RawURLEncoding.EncodeToString
+
+	switch publicKey := publicKey.(type) {
+	case *rsa.PublicKey:
+		return map[string]string{
+			"kty": "RSA",
+			"n":   encode(publicKey.N.Bytes()),
+			"e":   encode(big.NewInt(int64(publicKey.E)).Bytes()),
+		}, nil
+	case *ecdsa.PublicKey:
+		size := (publicKey.Curve.Params().BitSize + 7) / 8
+		return map[string]string{
+			"kty": "EC",
+			"crv": publicKey.Curve.Params().Name,
+			"x":   encode(publicKey.X.FillBytes(make([]byte, size))),
+			"y":   encode(publicKey.Y.FillBytes(make([]byte, size))),
+		}, nil
+	case ed25519.PublicKey:
+		return map[string]string{
+			"kty": "OKP",
+			"crv": "Ed25519",
+			"x":   encode(publicKey),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", publicKey)
+	}
+}
+
+// Returns the RFC 7638 thumbprint of a public key, the SHA-256 of its required JWK members in lexicographic order
+func jwkThumbprint(publicKey crypto.PublicKey) (string, error) {
+
+	jwk, err := publicJWK(publicKey)
+	if err != nil {
+		return "", err
+	}
+
+	var canonical string
+	switch jwk["kty"] {
+	case "RSA":
+		canonical = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, jwk["e"], jwk["n"])
+	case "EC":
+		canonical = fmt.Sprintf(`{"crv":%q,"kty":"EC","x":%q,"y":%q}`, jwk["crv"], jwk["x"], jwk["y"])
+	default:
+		canonical = fmt.Sprintf(`{"crv":%q,"kty":%q,"x":%q}`, jwk["crv"], jwk["kty"], jwk["x"])
+	}
+
+	thumbprint := sha256.Sum256([]byte(canonical))
+	return base64.RawURLEncoding.EncodeToString(thumbprint[:]), nil
+}
@@ -2,183 +2,205 @@ package auth
 
 import (
 	"context"
-	"crypto/rsa"
-	"encoding/json"
+	"crypto/sha256"
+	"errors"
+	"fmt"
 	"go-graphql-mongo-server/common"
 	"go-graphql-mongo-server/config"
 	"go-graphql-mongo-server/logger"
 	"go-graphql-mongo-server/models"
-	"io"
 	"net/http"
 	"strings"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-type OIDCInfo struct {
-	JwksURI string `json:"jwks_uri"`
-}
+var ErrUnauthenticated = errors.New("unauthenticated")
 
-type PublicKey struct {
-	Kty string `json:"kty"`
-	Kid string `json:"kid"`
-	E   string `json:"e"`
-	N   string `json:"n"`
-	Use string `json:"use"`
-	Key rsa.PublicKey
-}
+// Identity of the caller of a request
+type Identity struct {
+	UserName string
 
-var oidcInfo OIDCInfo
-var publicKeysMap = make(map[string]PublicKey)
+	// Scopes of a scoped personal access token or service key, nil when the permissions of the user are not restricted
+	Scopes []string
 
-func retrieveFromURL(url string) ([]byte, error) {
-	req, _ := http.NewRequest(http.MethodGet, url, nil)
-	httpClient := common.GetHTTPClient(true)
-	resp, err := httpClient.Do(req)
+	// Name of the service, when the caller authenticated with a service key or a client certificate
+	Service string
 
-	if err != nil {
-		logger.Log.Errorf("Error in retrieving OIDC info: %v", err)
-		return nil, err
-	}
+	// One of the models.AuthMethod constants
+	AuthMethod string
+}
 
-	defer resp.Body.Close()
+func Middleware(next http.Handler) http.Handler {
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		logger.Log.Errorf("Error in reading OIDC info: %v", err)
-		return nil, err
+	if config.Store.Auth.OidcEnabled && !isOIDCInfoLoaded() {
+		logger.Log.Info("No OIDC info found")
+		RefreshOIDCInfo()
 	}
 
-	return body, nil
-
-}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = WithClientCertificate(common.WithClientIP(common.WithRequestID(w, r)))
 
-func RefreshOIDCInfo() {
+		identity, err := Authenticate(r.Context(), r.Header.Get("Authorization"))
+		if err != nil {
+			common.RespondWithUnauthorized(w)
+			return
+		}
 
-	if !config.Store.Auth.OidcEnabled {
-		return
-	}
+		r = setIdentityInReq(r, identity)
+		next.ServeHTTP(w, r)
+	})
+}
 
-	body, err := retrieveFromURL(config.Store.Auth.OidcURL + "/.well-known/openid-configuration")
+// Authenticate returns the identity for the value of an Authorization header (Eg. "Bearer <token>")
+// It is used by the HTTP middleware as well as by other transports like WebSocket. Authentications are recorded in the audit log.
+func Authenticate(ctx context.Context, authorization string) (Identity, error) {
+	identity, err := authenticate(ctx, authorization)
 	if err != nil {
-		logger.Log.Errorf("Error in retrieving OIDC info: %v", err)
-		return
+		// Requests without credentials are not audited, they are only rejected when guests are disabled
+		if authMethod, reason := getAuthenticationFailure(ctx, authorization); reason != nil {
+			common.AuditAuthenticationFailure(ctx, authMethod, reason)
+		}
+		return identity, err
 	}
 
-	err = json.Unmarshal(body, &oidcInfo)
-	if err != nil {
-		logger.Log.Errorf("Error in parsing OIDC configuration info: %v", err)
-		return
+	// Guests did not authenticate
+	if identity.UserName != models.GuestUser {
+		common.AuditAuthenticationSuccess(ctx, identity.UserName, identity.Service, identity.AuthMethod)
 	}
+	return identity, nil
+}
 
-	jwksURLBody, err := retrieveFromURL(oidcInfo.JwksURI)
-	if err != nil {
-		logger.Log.Errorf("Error in retrieving OIDC public keys: %v", err)
-		return
-	}
+func authenticate(ctx context.Context, authorization string) (Identity, error) {
+	tokenString := strings.TrimPrefix(authorization, "Bearer ")
 
-	var publicKeys struct {
-		Keys []PublicKey `json:"keys"`
-	}
-	err = json.Unmarshal(jwksURLBody, &publicKeys)
-	if err != nil {
-		logger.Log.Errorf("Error in parsing OIDC public keys: %v", err)
-		return
-	}
+	if tokenString == "" {
+		// Client certificate (mTLS)
+		if certificate := getClientCertificate(ctx); certificate != nil {
+			return authenticateClientCertificate(certificate)
+		}
 
-	if len(publicKeys.Keys) == 0 {
-		logger.Log.Info("No OIDC public keys found")
-		return
+		//Guest User
+		if config.Store.GuestEnabled {
+			return Identity{UserName: models.GuestUser, AuthMethod: models.AuthMethodGuest}, nil
+		}
+		return Identity{}, ErrUnauthenticated
 	}
 
-	// Clear the old publicKeysMap
-	publicKeysMap = make(map[string]PublicKey)
-
-	for _, key := range publicKeys.Keys {
-		if key.Use == "sig" && key.Kty == "RSA" {
-			key.Key, err = generateRSAPublicKey(key.N, key.E)
-			if err != nil {
-				logger.Log.Errorf("Error in generating RSA public key: %v", err)
-			} else {
-				publicKeysMap[key.Kid] = key
-			}
-		}
+	if isServiceKey(tokenString) {
+		//Internal User (Eg. Other backend services)
+		return validateServiceKey(ctx, tokenString)
 	}
 
-	logger.Log.Info("Refreshed OIDC info")
+	//Validate Token
+	return validateToken(ctx, tokenString)
 }
 
-func Middleware(next http.Handler) http.Handler {
-
-	if config.Store.Auth.OidcEnabled && (len(oidcInfo.JwksURI) == 0 || len(publicKeysMap) == 0) {
-		logger.Log.Info("No OIDC info found")
-		RefreshOIDCInfo()
+// Returns the auth method attempted by a rejected request, when it is known, and why it was rejected
+// The reason is nil for a request without credentials.
+// JWTs are not parsed again, so the OIDC tokens and personal access tokens are not told apart.
+func getAuthenticationFailure(ctx context.Context, authorization string) (string, error) {
+	tokenString := strings.TrimPrefix(authorization, "Bearer ")
+
+	switch {
+	case tokenString == "" && getClientCertificate(ctx) != nil:
+		return models.AuthMethodMTLS, fmt.Errorf("client certificate %v is not accepted", getClientCertificate(ctx).Subject)
+	case tokenString == "":
+		return "", nil
+	case isServiceKey(tokenString):
+		return models.AuthMethodServiceKey, errors.New("invalid service key")
+	default:
+		return "", errors.New("invalid token")
 	}
+}
 
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		tokenString := r.Header.Get("Authorization")
-		tokenString = strings.TrimPrefix(tokenString, "Bearer ")
+// WithIdentity returns a copy of the context carrying the authenticated user name, token scopes, service name and auth method
+func WithIdentity(ctx context.Context, identity Identity) context.Context {
+	ctx = context.WithValue(ctx, models.UserContextKey, identity.UserName)
+	if identity.Scopes != nil {
+		ctx = context.WithValue(ctx, models.ScopesContextKey, identity.Scopes)
+	}
+	if identity.Service != "" {
+		ctx = context.WithValue(ctx, models.ServiceContextKey, identity.Service)
+	}
+	if identity.AuthMethod != "" {
+		ctx = context.WithValue(ctx, models.AuthMethodContextKey, identity.AuthMethod)
+	}
+	return ctx
+}
 
-		if tokenString == "" {
-			//Guest User
+// IsGuestRequest reports whether a request will get the guest identity, as it has no credentials
+func IsGuestRequest(r *http.Request) bool {
+	if !config.Store.GuestEnabled || strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ") != "" {
+		return false
+	}
+	return r.TLS == nil || len(r.TLS.VerifiedChains) == 0
+}
 
-			//To allow the user to access the protected routes, comment the following line
-			common.RespondWithUnauthorized(w)
+func setIdentityInReq(r *http.Request, identity Identity) *http.Request {
+	return r.WithContext(WithIdentity(r.Context(), identity))
+}
 
-			// To stop the user from accessing the protected routes, comment the following line
-			// r = setUserNameInReq(r, models.GuestUser)
-			// next.ServeHTTP(w, r)
+func validateToken(ctx context.Context, tokenString string) (Identity, error) {
+	token, issuer, err := parseToken(ctx, tokenString)
+	if err != nil {
+		logger.Log.Error("Error while parsing token")
+		return Identity{}, ErrUnauthenticated
+	}
 
-		} else if tokenString == config.Store.SecretToken {
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return Identity{}, ErrUnauthenticated
+	}
 
-			//Internal User (Eg. Other backend services)
-			r = setUserNameInReq(r, models.InternalUser)
-			next.ServeHTTP(w, r)
+	inHouse := issuer == nil
+	usernameClaim := "sub"
+	if !inHouse {
+		usernameClaim = issuer.UsernameClaim
+	}
 
-		} else {
-			//Validate Token
-			validateToken(tokenString, next, w, r)
-		}
+	userName, ok := claims[usernameClaim].(string)
+	if !ok || userName == "" {
+		return Identity{}, ErrUnauthenticated
+	}
 
-	})
-}
+	// The user names of the server identities can't be claimed by an OIDC provider
+	if !inHouse && models.IsReservedUserName(userName) {
+		logger.Log.Errorf("OIDC token of %v claims the reserved user name %v", issuer.Issuer, userName)
+		return Identity{}, ErrUnauthenticated
+	}
 
-func setUserNameInReq(r *http.Request, userName string) *http.Request {
-	return r.WithContext(context.WithValue(r.Context(), models.UserContextKey, userName))
-}
+	identity := Identity{UserName: userName, AuthMethod: models.AuthMethodOIDC}
+	if inHouse {
+		identity.AuthMethod = models.AuthMethodPAT
+	}
 
-func validateToken(tokenString string, next http.Handler, w http.ResponseWriter, r *http.Request) {
-	token, err := parseToken(r.Context(), tokenString)
-	if err != nil {
-		logger.Log.Error("Error while parsing token")
-		common.RespondWithUnauthorized(w)
-		return
+	if inHouse {
+		recordTokenUsage(sha256.Sum256([]byte(tokenString)), common.GetClientIP(ctx))
 	}
 
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if ok && token.Valid && claims["sub"] != nil {
-		r = setUserNameInReq(r, claims["sub"].(string))
-		next.ServeHTTP(w, r)
-	} else {
-		common.RespondWithJSON(w, http.StatusUnauthorized, map[string]string{"message": "Unauthorized"})
+	// Only in-house tokens are scoped, the scope claim of OIDC tokens has another meaning
+	if scope, _ := claims["scope"].(string); inHouse && scope != "" {
+		identity.Scopes = strings.Fields(scope)
 	}
+	return identity, nil
 }
 
-func parseToken(ctx context.Context, tokenString string) (*jwt.Token, error) {
+// Parses and verifies a token, issuer is the trusted OIDC issuer of the token or nil for a personal access token issued by this server
+func parseToken(ctx context.Context, tokenString string) (parsedToken *jwt.Token, issuer *config.OIDCIssuer, err error) {
 
-	parsedToken, err := jwt.Parse(tokenString, func(token *jwt.Token) (pubKey interface{}, err error) {
+	parsedToken, err = jwt.Parse(tokenString, func(token *jwt.Token) (pubKey interface{}, err error) {
 
-		if config.Store.Auth.OidcEnabled {
-			pubKey, err = validateOIDCToken(ctx, token, tokenString)
+		// Tokens signed by one of the in-house keys are never sent to the OIDC flow
+		if config.Store.Auth.OidcEnabled && !isSignedInHouse(token) {
+			pubKey, issuer, err = validateOIDCToken(ctx, token, tokenString)
 			if err == nil {
 				return
 			}
 		}
 
-		pubKey, err = validateJwtInHouse(ctx, token, tokenString)
-
-		return
+		return validateJwtInHouse(ctx, token, tokenString)
 	})
 
-	return parsedToken, err
+	return parsedToken, issuer, err
 }
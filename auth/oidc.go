@@ -0,0 +1,245 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"go-graphql-mongo-server/common"
+	"go-graphql-mongo-server/config"
+	"go-graphql-mongo-server/logger"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type OIDCInfo struct {
+	JwksURI string `json:"jwks_uri"`
+}
+
+// A JSON Web Key (RFC 7517) published by an OIDC issuer
+type PublicKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+
+	// RSA keys
+	E string `json:"e"`
+	N string `json:"n"`
+
+	// EC and OKP keys
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+
+	Key crypto.PublicKey `json:"-"`
+}
+
+func retrieveFromURL(ctx context.Context, url string) ([]byte, http.Header, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	httpClient := common.GetHTTPClient(true)
+	resp, err := httpClient.Do(req)
+
+	if err != nil {
+		logger.Log.Errorf("Error in retrieving OIDC info: %v", err)
+		return nil, nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("unexpected status %v from %v", resp.StatusCode, url)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		logger.Log.Errorf("Error in reading OIDC info: %v", err)
+		return nil, nil, err
+	}
+
+	return body, resp.Header, nil
+
+}
+
+// RefreshOIDCInfo refreshes the public keys of the trusted OIDC issuers which reached the max-age of their Cache-Control
+func RefreshOIDCInfo() {
+
+	if !config.Store.Auth.OidcEnabled {
+		return
+	}
+
+	for i := range config.Store.OidcIssuers {
+		cache := getJWKSCache(&config.Store.OidcIssuers[i])
+		if cache.isExpired() {
+			cache.refresh()
+		}
+	}
+}
+
+// Reports whether the public keys of every trusted issuer were retrieved
+func isOIDCInfoLoaded() bool {
+	for i := range config.Store.OidcIssuers {
+		if !getJWKSCache(&config.Store.OidcIssuers[i]).isLoaded() {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the trusted issuer with the given iss claim
+func findOIDCIssuer(iss string) *config.OIDCIssuer {
+	iss = strings.TrimSuffix(iss, "/")
+	for i := range config.Store.OidcIssuers {
+		if strings.TrimSuffix(config.Store.OidcIssuers[i].Issuer, "/") == iss {
+			return &config.Store.OidcIssuers[i]
+		}
+	}
+	return nil
+}
+
+// Verifies the claims of a token issued by one of the trusted issuers and returns the key which signed it
+func validateOIDCToken(_ context.Context, token *jwt.Token, _ string) (interface{}, *config.OIDCIssuer, error) {
+
+	logger.Log.Info("Validating JWT token for OIDC flow")
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, nil, errors.New("invalid claims")
+	}
+
+	iss, _ := claims["iss"].(string)
+	issuer := findOIDCIssuer(iss)
+	if issuer == nil {
+		return nil, nil, fmt.Errorf("untrusted issuer %q", iss)
+	}
+
+	// aud is either a string or an array of strings
+	audiences, err := claims.GetAudience()
+	if err != nil || !containsAny(audiences, issuer.Audiences) {
+		return nil, nil, errors.New("invalid audience")
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return nil, nil, errors.New("missing kid")
+	}
+
+	key, err := getJWKSCache(issuer).key(kid)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if (key.Alg != "" && key.Alg != token.Method.Alg()) || !isKeyForMethod(key.Key, token.Method) {
+		logger.Log.Error("Invalid JWT token")
+		return nil, nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+
+	return key.Key, issuer, nil
+}
+
+// Reports whether a key can verify the signatures of a signing method
+func isKeyForMethod(key crypto.PublicKey, method jwt.SigningMethod) bool {
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		switch method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+			return true
+		}
+	case *ecdsa.PublicKey:
+		ecMethod, ok := method.(*jwt.SigningMethodECDSA)
+		return ok && ecMethod.CurveBits == key.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		_, ok := method.(*jwt.SigningMethodEd25519)
+		return ok
+	}
+	return false
+}
+
+// Returns the public key of a RSA, EC (P-256, P-384, P-521) or OKP (Ed25519) JWK
+func parseJWK(jwk PublicKey) (crypto.PublicKey, error) {
+
+	decode := base64.RawURLEncoding.DecodeString
+
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decode(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if len(n) == 0 || !exponent.IsInt64() || exponent.Int64() < 2 || exponent.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA key")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported EC curve %v", jwk.Crv)
+		}
+		x, err := decode(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("invalid EC key")
+		}
+		return key, nil
+
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported OKP curve %v", jwk.Crv)
+		}
+		x, err := decode(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+
+	default:
+		return nil, fmt.Errorf("unsupported key type %v", jwk.Kty)
+	}
+}
+
+// Reports whether the values have at least one element in common
+func containsAny(values []string, expected []string) bool {
+	for _, value := range values {
+		for _, e := range expected {
+			if value == e {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"go-graphql-mongo-server/config"
+	"go-graphql-mongo-server/logger"
+	"go-graphql-mongo-server/models"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Prefix of the service keys, it tells them apart from the JWTs
+const serviceKeyPrefix = "sk_"
+
+// GenerateServiceKey creates a new random key for a service, only its hash is meant to be stored
+func GenerateServiceKey(key *models.ServiceKey) error {
+
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		logger.Log.Error("Error generating service key: " + err.Error())
+		return err
+	}
+
+	key.KeyString = serviceKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+	key.KeyHash = sha256.Sum256([]byte(key.KeyString))
+	return nil
+}
+
+func isServiceKey(tokenString string) bool {
+	return strings.HasPrefix(tokenString, serviceKeyPrefix)
+}
+
+// Name of the service calling the server with BOOTSTRAP_SERVICE_KEY
+const bootstrapServiceName = "bootstrap"
+
+// Returns the identity of the service owning a key, services call the server as the internal user
+func validateServiceKey(ctx context.Context, keyString string) (Identity, error) {
+
+	// The bootstrap key is not stored and has every permission, it is only meant to create the first keys and roles
+	bootstrapKey := config.Store.BootstrapServiceKey
+	if bootstrapKey != "" && subtle.ConstantTimeCompare([]byte(keyString), []byte(bootstrapKey)) == 1 {
+		logger.Log.Warn("The bootstrap service key was used, remove BOOTSTRAP_SERVICE_KEY once the setup is done")
+		return Identity{UserName: models.InternalUser, Service: bootstrapServiceName, AuthMethod: models.AuthMethodServiceKey}, nil
+	}
+
+	var key models.ServiceKey
+	err := models.FindOne(
+		ctx,
+		models.ServiceKeyCollection,
+		models.ValidServiceKeyFilter(sha256.Sum256([]byte(keyString))),
+		bson.M{"name": 1, "scopes": 1},
+		&key,
+	)
+	if err != nil {
+		logger.Log.Error("Invalid service key")
+		return Identity{}, ErrUnauthenticated
+	}
+
+	return Identity{UserName: models.InternalUser, Service: key.Name, Scopes: key.Scopes, AuthMethod: models.AuthMethodServiceKey}, nil
+}
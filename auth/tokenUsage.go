@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"context"
+	"go-graphql-mongo-server/logger"
+	"go-graphql-mongo-server/models"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type tokenUsage struct {
+	lastUsedAt time.Time
+	lastUsedIP string
+	count      int64
+}
+
+// Usage of the personal access tokens since the last flush, by token hash
+var tokenUsages = struct {
+	sync.Mutex
+	usages map[[32]byte]*tokenUsage
+}{usages: map[[32]byte]*tokenUsage{}}
+
+// Records that a personal access token was used, it is written to the database by the next FlushTokenUsage
+func recordTokenUsage(tokenHash [32]byte, clientIP string) {
+	tokenUsages.Lock()
+	defer tokenUsages.Unlock()
+
+	usage, ok := tokenUsages.usages[tokenHash]
+	if !ok {
+		usage = &tokenUsage{}
+		tokenUsages.usages[tokenHash] = usage
+	}
+	usage.lastUsedAt = time.Now()
+	usage.lastUsedIP = clientIP
+	usage.count++
+}
+
+// FlushTokenUsage writes the token usage recorded since the last flush with a single bulk write
+// Usage is kept in memory in between, so that validating a token doesn't cost a database write.
+func FlushTokenUsage() {
+	tokenUsages.Lock()
+	usages := tokenUsages.usages
+	tokenUsages.usages = map[[32]byte]*tokenUsage{}
+	tokenUsages.Unlock()
+
+	if len(usages) == 0 {
+		return
+	}
+
+	writeModels := make([]mongo.WriteModel, 0, len(usages))
+	for tokenHash, usage := range usages {
+		writeModels = append(writeModels, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"$or": []bson.M{{"tokenHash": tokenHash}, {"previousTokenHash": tokenHash}}}).
+			SetUpdate(bson.M{
+				"$max": bson.M{"lastUsedAt": usage.lastUsedAt},
+				"$set": bson.M{"lastUsedIP": usage.lastUsedIP},
+				"$inc": bson.M{"useCount": usage.count},
+			}))
+	}
+
+	// Tokens revoked in the meantime are simply not matched
+	err := models.BulkWrite(context.TODO(), models.TokenCollection, writeModels, options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		return
+	}
+
+	logger.Log.Infof("Flushed the usage of %d tokens", len(usages))
+}
@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"go-graphql-mongo-server/common"
+	"go-graphql-mongo-server/config"
+	"go-graphql-mongo-server/logger"
+	"go-graphql-mongo-server/models"
+	"net/http"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	jwksPath          = "/.well-known/jwks.json"
+	introspectionPath = "/api/introspect"
+)
+
+// JWKSHandler publishes the public keys of the in-house keyring, so that other services can verify the PATs offline
+func JWKSHandler(w http.ResponseWriter, _ *http.Request) {
+
+	ring, err := getKeyring()
+	if err != nil {
+		common.RespondWithJSON(w, http.StatusServiceUnavailable, map[string]string{"message": "signing keys are not available"})
+		return
+	}
+
+	keys := make([]map[string]string, 0, len(ring.keys))
+	for _, key := range ring.keys {
+		jwk, err := publicJWK(key.PublicKey)
+		if err != nil {
+			logger.Log.Errorf("Error in encoding JWK %v: %v", key.ID, err)
+			continue
+		}
+		jwk["kid"] = key.ID
+		jwk["alg"] = key.Method.Alg()
+		jwk["use"] = "sig"
+		keys = append(keys, jwk)
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	common.RespondWithJSON(w, http.StatusOK, map[string]interface{}{"keys": keys})
+}
+
+// DiscoveryHandler publishes a minimal OpenID Provider configuration for the in-house tokens
+// It needs JWT_ISSUER, an issuer taken from the Host header could be chosen by the client and cached by proxies.
+// The tokens are access tokens, not ID tokens, so their signing algorithms are only given by the "alg" of the JWKS.
+func DiscoveryHandler(w http.ResponseWriter, _ *http.Request) {
+
+	if config.Store.JWTIssuer == "" {
+		common.RespondWithJSON(w, http.StatusNotFound, map[string]string{"message": "JWT_ISSUER is not configured"})
+		return
+	}
+	issuer := strings.TrimSuffix(config.Store.JWTIssuer, "/")
+
+	configuration := map[string]interface{}{
+		"issuer":                   issuer,
+		"jwks_uri":                 issuer + jwksPath,
+		"response_types_supported": []string{"token"},
+		"subject_types_supported":  []string{"public"},
+	}
+	if config.Store.IntrospectionEnabled {
+		configuration["introspection_endpoint"] = issuer + introspectionPath
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	common.RespondWithJSON(w, http.StatusOK, configuration)
+}
+
+// IntrospectionHandler implements RFC 7662 token introspection for the in-house tokens
+// Unlike offline verification, it also checks the tokens collection, so revoked tokens are reported as inactive.
+func IntrospectionHandler(w http.ResponseWriter, r *http.Request) {
+
+	if !config.Store.IntrospectionEnabled {
+		common.RespondWithJSON(w, http.StatusNotFound, map[string]string{"message": "introspection is not enabled"})
+		return
+	}
+
+	// Guests are not authorized callers of the introspection endpoint
+	if r.Context().Value(models.UserContextKey) == models.GuestUser {
+		common.RespondWithUnauthorized(w)
+		return
+	}
+
+	tokenString := r.PostFormValue("token")
+	if tokenString == "" {
+		common.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		return validateJwtInHouse(r.Context(), token, tokenString)
+	})
+	if err != nil || !token.Valid {
+		common.RespondWithJSON(w, http.StatusOK, map[string]bool{"active": false})
+		return
+	}
+
+	claims, _ := token.Claims.(jwt.MapClaims)
+	response := map[string]interface{}{
+		"active":     true,
+		"token_type": "Bearer",
+		"sub":        claims["sub"],
+		"username":   claims["sub"],
+		"exp":        claims["exp"],
+		"iat":        claims["iat"],
+	}
+	for _, claim := range []string{"scope", "iss"} {
+		if value, ok := claims[claim]; ok {
+			response[claim] = value
+		}
+	}
+
+	common.RespondWithJSON(w, http.StatusOK, response)
+}
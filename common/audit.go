@@ -0,0 +1,101 @@
+package common
+
+import (
+	"context"
+	"go-graphql-mongo-server/logger"
+	"go-graphql-mongo-server/models"
+	"strings"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Longer string arguments are truncated in the audit log
+const maxAuditStringLength = 1000
+
+// AuditMutation wraps a mutation resolver so that every call is appended to the audit log, whatever its outcome
+// The entry is written once the resolver returns, resolvers can add the changes of an update with SetAuditChanges.
+func AuditMutation(resolve graphql.FieldResolveFn) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+
+		userName, _ := p.Context.Value(models.UserContextKey).(string)
+		entry := &models.AuditLog{
+			Timestamp:  time.Now(),
+			Actor:      userName,
+			Service:    GetServiceName(p),
+			AuthMethod: GetAuthMethod(p),
+			ClientIP:   GetClientIP(p.Context),
+			RequestID:  GetRequestID(p.Context),
+			Operation:  p.Info.Operation.GetOperation(),
+			Name:       p.Info.FieldName,
+			Args:       auditArgs(p.Args),
+		}
+
+		p.Context = context.WithValue(p.Context, models.AuditLogContextKey, entry)
+		result, err := resolve(p)
+
+		entry.Outcome = models.AuditOutcomeSuccess
+		if err != nil {
+			entry.Outcome = models.AuditOutcomeFailure
+			entry.Error = err.Error()
+		}
+
+		// The entry is also written when the operation timed out or the client went away
+		models.InsertAuditLog(context.Background(), *entry)
+		return result, err
+	}
+}
+
+// SetAuditChanges records the fields changed by an update in the audit log entry of the mutation
+func SetAuditChanges(p graphql.ResolveParams, before interface{}, after interface{}) {
+
+	entry, ok := p.Context.Value(models.AuditLogContextKey).(*models.AuditLog)
+	if !ok {
+		return
+	}
+
+	var err error
+	entry.Before, entry.After, err = models.DiffDocuments(before, after)
+	if err != nil {
+		logger.Log.Errorf("Error computing the changes of '%v' for the audit log: %v", p.Info.FieldName, err)
+	}
+}
+
+// Returns a sanitized copy of the arguments of a mutation, without the values of the sensitive arguments
+func auditArgs(args map[string]interface{}) bson.M {
+	if len(args) == 0 {
+		return nil
+	}
+
+	result := bson.M{}
+	for name, value := range args {
+		result[name] = auditValue(name, value)
+	}
+	return result
+}
+
+func auditValue(name string, value interface{}) interface{} {
+
+	if models.IsSensitiveAuditField(name) {
+		return models.AuditRedacted
+	}
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		return auditArgs(value)
+	case []interface{}:
+		values := make([]interface{}, 0, len(value))
+		for _, item := range value {
+			values = append(values, auditValue(name, item))
+		}
+		return values
+	case string:
+		if len(value) > maxAuditStringLength {
+			value = strings.ToValidUTF8(value[:maxAuditStringLength], "")
+		}
+		return getHTMLSanitizer().Sanitize(value)
+	default:
+		return value
+	}
+}
@@ -0,0 +1,186 @@
+package common
+
+import (
+	"context"
+	"go-graphql-mongo-server/logger"
+	"go-graphql-mongo-server/models"
+	"sync"
+	"time"
+)
+
+// Maximum number of client IPs whose rejected authentications are counted separately between two flushes,
+// the failures of the other IPs are counted together
+const maxAuthenticationFailureIPs = 10000
+
+// Key under which the failures of the IPs beyond maxAuthenticationFailureIPs are counted, their entry has no client IP
+const otherClientIPs = ""
+
+// Maximum number of identities whose successful authentications are counted separately between two flushes,
+// the successes of the other identities are counted together under an entry without actor
+const maxAuthenticationSuccessIdentities = 10000
+
+// Rejected authentications of a client IP which were not written to the audit log yet
+type authenticationFailure struct {
+	authMethod string
+	reason     string
+	lastAt     time.Time
+	count      int64
+}
+
+// Rejected authentications since the last flush, by client IP
+var authenticationFailures = struct {
+	sync.Mutex
+	failures map[string]*authenticationFailure
+}{failures: map[string]*authenticationFailure{}}
+
+// Identity whose successful authentications are counted together
+type authenticationIdentity struct {
+	actor      string
+	service    string
+	authMethod string
+}
+
+// Successful authentications of an identity which were not written to the audit log yet
+type authenticationSuccess struct {
+	clientIP string
+	lastAt   time.Time
+	count    int64
+}
+
+// Successful authentications since the last flush, by identity
+var authenticationSuccesses = struct {
+	sync.Mutex
+	successes map[authenticationIdentity]*authenticationSuccess
+}{successes: map[authenticationIdentity]*authenticationSuccess{}}
+
+// AuditAuthenticationFailure appends a rejected authentication to the audit log
+// Only the first failure of a client IP is written right away, the next ones are counted and written as a single entry
+// by FlushAuthentications, so that repeated failures don't cost a database write each.
+func AuditAuthenticationFailure(ctx context.Context, authMethod string, reason error) {
+
+	clientIP := GetClientIP(ctx)
+	if !countAuthenticationFailure(clientIP, authMethod, reason.Error()) {
+		return
+	}
+
+	models.InsertAuditLog(context.Background(), models.AuditLog{
+		AuthMethod: authMethod,
+		ClientIP:   clientIP,
+		RequestID:  GetRequestID(ctx),
+		Operation:  models.AuditOperationAuthentication,
+		Name:       "Authenticate",
+		Outcome:    models.AuditOutcomeFailure,
+		Error:      reason.Error(),
+	})
+}
+
+// Counts a rejected authentication, it reports whether the failure is the first one of its client IP since the last flush
+func countAuthenticationFailure(clientIP string, authMethod string, reason string) bool {
+	authenticationFailures.Lock()
+	defer authenticationFailures.Unlock()
+
+	key := clientIP
+	if _, ok := authenticationFailures.failures[key]; !ok && len(authenticationFailures.failures) >= maxAuthenticationFailureIPs {
+		key = otherClientIPs
+	}
+
+	failure, ok := authenticationFailures.failures[key]
+	if !ok {
+		// The first failure is written right away, so it is not counted
+		authenticationFailures.failures[key] = &authenticationFailure{}
+		return true
+	}
+
+	failure.authMethod = authMethod
+	failure.reason = reason
+	failure.lastAt = time.Now()
+	failure.count++
+	return false
+}
+
+// AuditAuthenticationSuccess counts a successful authentication, which is written to the audit log by FlushAuthentications
+// Every request is authenticated, so the successes are only written as a single entry per identity and flush.
+func AuditAuthenticationSuccess(ctx context.Context, actor string, service string, authMethod string) {
+	authenticationSuccesses.Lock()
+	defer authenticationSuccesses.Unlock()
+
+	key := authenticationIdentity{actor: actor, service: service, authMethod: authMethod}
+	if _, ok := authenticationSuccesses.successes[key]; !ok && len(authenticationSuccesses.successes) >= maxAuthenticationSuccessIdentities {
+		key = authenticationIdentity{}
+	}
+
+	success, ok := authenticationSuccesses.successes[key]
+	if !ok {
+		success = &authenticationSuccess{}
+		authenticationSuccesses.successes[key] = success
+	}
+
+	success.clientIP = GetClientIP(ctx)
+	success.lastAt = time.Now()
+	success.count++
+}
+
+// FlushAuthentications writes the authentications counted since the last flush to the audit log
+func FlushAuthentications() {
+	flushAuthenticationFailures()
+	flushAuthenticationSuccesses()
+}
+
+// Writes an entry for every client IP whose rejected authentications were counted since the last flush,
+// with the number of failures, the auth method and the reason of the last one
+func flushAuthenticationFailures() {
+	authenticationFailures.Lock()
+	failures := authenticationFailures.failures
+	authenticationFailures.failures = map[string]*authenticationFailure{}
+	authenticationFailures.Unlock()
+
+	var flushed int
+	for clientIP, failure := range failures {
+		if failure.count == 0 {
+			continue
+		}
+
+		models.InsertAuditLog(context.Background(), models.AuditLog{
+			Timestamp:  failure.lastAt,
+			AuthMethod: failure.authMethod,
+			ClientIP:   clientIP,
+			Operation:  models.AuditOperationAuthentication,
+			Name:       "Authenticate",
+			Outcome:    models.AuditOutcomeFailure,
+			Error:      failure.reason,
+			Count:      failure.count,
+		})
+		flushed++
+	}
+
+	if flushed > 0 {
+		logger.Log.Infof("Flushed the rejected authentications of %d client IPs", flushed)
+	}
+}
+
+// Writes an entry for every identity which authenticated since the last flush, with the number of successes
+// and the client IP of the last one
+func flushAuthenticationSuccesses() {
+	authenticationSuccesses.Lock()
+	successes := authenticationSuccesses.successes
+	authenticationSuccesses.successes = map[authenticationIdentity]*authenticationSuccess{}
+	authenticationSuccesses.Unlock()
+
+	for identity, success := range successes {
+		models.InsertAuditLog(context.Background(), models.AuditLog{
+			Timestamp:  success.lastAt,
+			Actor:      identity.actor,
+			Service:    identity.service,
+			AuthMethod: identity.authMethod,
+			ClientIP:   success.clientIP,
+			Operation:  models.AuditOperationAuthentication,
+			Name:       "Authenticate",
+			Outcome:    models.AuditOutcomeSuccess,
+			Count:      success.count,
+		})
+	}
+
+	if len(successes) > 0 {
+		logger.Log.Infof("Flushed the successful authentications of %d identities", len(successes))
+	}
+}
@@ -0,0 +1,196 @@
+package common
+
+import (
+	"go-graphql-mongo-server/apperror"
+	"regexp"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const maxFilterDepth = 10
+
+// Allow-list of filter operators and their MongoDB counterparts
+// Anything not listed here (Eg. $where, $expr) can never reach the database.
+var filterOperators = map[string]string{
+	"_eq":  "$eq",
+	"_ne":  "$ne",
+	"_in":  "$in",
+	"_nin": "$nin",
+	"_gt":  "$gt",
+	"_lt":  "$lt",
+	"_gte": "$gte",
+	"_lte": "$lte",
+}
+
+// BuildMongoFilter translates a GraphQL filter input into a MongoDB filter
+// Only the dotted field paths present in allowedFields can be filtered on.
+// Eg. {name: {_startsWith: "A"}, OR: [{id: {_lt: 10}}, {address: {city: {_eq: "Kolkata"}}}]}
+func BuildMongoFilter(input map[string]interface{}, allowedFields []string) (bson.M, error) {
+	allowedFieldSet := make(map[string]bool, len(allowedFields))
+	for _, field := range allowedFields {
+		allowedFieldSet[field] = true
+	}
+	return buildMongoFilter(input, allowedFieldSet, "", 0)
+}
+
+func buildMongoFilter(input map[string]interface{}, allowedFields map[string]bool, prefix string, depth int) (bson.M, error) {
+
+	if depth > maxFilterDepth {
+		return nil, apperror.New(apperror.BadUserInput, "filter is nested too deep")
+	}
+
+	filter := bson.M{}
+	var conditions []interface{}
+
+	for key, value := range input {
+
+		switch key {
+
+		case "AND", "OR":
+			subFilters, err := buildSubFilters(value, allowedFields, prefix, depth)
+			if err != nil {
+				return nil, err
+			}
+			if len(subFilters) > 0 {
+				conditions = append(conditions, bson.M{"$" + strings.ToLower(key): subFilters})
+			}
+
+		case "NOT":
+			subInput, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, apperror.New(apperror.BadUserInput, "invalid value for NOT")
+			}
+			subFilter, err := buildMongoFilter(subInput, allowedFields, prefix, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			conditions = append(conditions, bson.M{"$nor": []interface{}{subFilter}})
+
+		default:
+			path := prefix + key
+			subInput, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, apperror.Newf(apperror.BadUserInput, "invalid filter for %v", path)
+			}
+
+			if allowedFields[path] {
+				fieldFilter, err := buildFieldFilter(path, subInput)
+				if err != nil {
+					return nil, err
+				}
+				filter[path] = fieldFilter
+				continue
+			}
+
+			if !hasAllowedChild(allowedFields, path) {
+				return nil, apperror.Newf(apperror.BadUserInput, "filtering on %v is not allowed", path)
+			}
+
+			// Nested object, Eg. address: {city: {...}}
+			subFilter, err := buildMongoFilter(subInput, allowedFields, path+".", depth+1)
+			if err != nil {
+				return nil, err
+			}
+			conditions = append(conditions, subFilter)
+		}
+	}
+
+	if len(conditions) > 0 {
+		if len(filter) > 0 {
+			conditions = append(conditions, filter)
+		}
+		if len(conditions) == 1 {
+			return conditions[0].(bson.M), nil
+		}
+		return bson.M{"$and": conditions}, nil
+	}
+
+	return filter, nil
+}
+
+func buildSubFilters(value interface{}, allowedFields map[string]bool, prefix string, depth int) ([]interface{}, error) {
+
+	subInputs, ok := value.([]interface{})
+	if !ok {
+		return nil, apperror.New(apperror.BadUserInput, "invalid value for AND/OR")
+	}
+
+	var subFilters []interface{}
+	for _, subInput := range subInputs {
+		subInputMap, ok := subInput.(map[string]interface{})
+		if !ok {
+			return nil, apperror.New(apperror.BadUserInput, "invalid value for AND/OR")
+		}
+		subFilter, err := buildMongoFilter(subInputMap, allowedFields, prefix, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		subFilters = append(subFilters, subFilter)
+	}
+	return subFilters, nil
+}
+
+func buildFieldFilter(path string, operators map[string]interface{}) (bson.M, error) {
+
+	fieldFilter := bson.M{}
+
+	for operator, operand := range operators {
+
+		if mongoOperator, ok := filterOperators[operator]; ok {
+			fieldFilter[mongoOperator] = operand
+			continue
+		}
+
+		switch operator {
+		case "_contains", "_startsWith":
+			text, ok := operand.(string)
+			if !ok {
+				return nil, apperror.Newf(apperror.BadUserInput, "%v on %v expects a string", operator, path)
+			}
+
+			// User input is always matched literally
+			pattern := regexp.QuoteMeta(text)
+			if operator == "_startsWith" {
+				pattern = "^" + pattern
+			}
+			if _, exists := fieldFilter["$regex"]; exists {
+				return nil, apperror.Newf(apperror.BadUserInput, "_contains and _startsWith can not be used together on %v", path)
+			}
+			fieldFilter["$regex"] = pattern
+
+		default:
+			return nil, apperror.Newf(apperror.BadUserInput, "operator %v is not allowed", operator)
+		}
+	}
+
+	return fieldFilter, nil
+}
+
+func hasAllowedChild(allowedFields map[string]bool, path string) bool {
+	for field := range allowedFields {
+		if strings.HasPrefix(field, path+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// PrefixFilterFields moves all the fields of a filter built by BuildMongoFilter under a prefix
+// Eg. to match the fullDocument of a change stream event
+func PrefixFilterFields(filter bson.M, prefix string) bson.M {
+	prefixed := bson.M{}
+	for key, value := range filter {
+		switch key {
+		case "$and", "$or", "$nor":
+			var subFilters []interface{}
+			for _, subFilter := range value.([]interface{}) {
+				subFilters = append(subFilters, PrefixFilterFields(subFilter.(bson.M), prefix))
+			}
+			prefixed[key] = subFilters
+		default:
+			prefixed[prefix+key] = value
+		}
+	}
+	return prefixed
+}
@@ -0,0 +1,137 @@
+package common
+
+import (
+	"errors"
+	"go-graphql-mongo-server/apperror"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var testFilterFields = []string{"name", "age", "address.city"}
+
+func TestBuildMongoFilter(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		input map[string]interface{}
+		want  bson.M
+	}{
+		{
+			name:  "operator",
+			input: map[string]interface{}{"age": map[string]interface{}{"_gte": 18}},
+			want:  bson.M{"age": bson.M{"$gte": 18}},
+		},
+		{
+			name:  "text is matched literally",
+			input: map[string]interface{}{"name": map[string]interface{}{"_startsWith": "a.*"}},
+			want:  bson.M{"name": bson.M{"$regex": `^a\.\*`}},
+		},
+		{
+			name:  "nested field",
+			input: map[string]interface{}{"address": map[string]interface{}{"city": map[string]interface{}{"_eq": "Kolkata"}}},
+			want:  bson.M{"address.city": bson.M{"$eq": "Kolkata"}},
+		},
+		{
+			name: "OR",
+			input: map[string]interface{}{"OR": []interface{}{
+				map[string]interface{}{"name": map[string]interface{}{"_eq": "A"}},
+				map[string]interface{}{"age": map[string]interface{}{"_lt": 10}},
+			}},
+			want: bson.M{"$or": []interface{}{
+				bson.M{"name": bson.M{"$eq": "A"}},
+				bson.M{"age": bson.M{"$lt": 10}},
+			}},
+		},
+		{
+			name:  "NOT",
+			input: map[string]interface{}{"NOT": map[string]interface{}{"age": map[string]interface{}{"_eq": 1}}},
+			want:  bson.M{"$nor": []interface{}{bson.M{"age": bson.M{"$eq": 1}}}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := BuildMongoFilter(test.input, testFilterFields)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestBuildMongoFilterRejections(t *testing.T) {
+
+	deepFilter := map[string]interface{}{"age": map[string]interface{}{"_eq": 1}}
+	for i := 0; i <= maxFilterDepth; i++ {
+		deepFilter = map[string]interface{}{"NOT": deepFilter}
+	}
+
+	tests := []struct {
+		name  string
+		input map[string]interface{}
+	}{
+		{
+			name:  "field not in the allow-list",
+			input: map[string]interface{}{"password": map[string]interface{}{"_eq": "x"}},
+		},
+		{
+			name:  "nested field not in the allow-list",
+			input: map[string]interface{}{"address": map[string]interface{}{"street": map[string]interface{}{"_eq": "x"}}},
+		},
+		{
+			name:  "parent of an allowed field used as a field",
+			input: map[string]interface{}{"address": map[string]interface{}{"_eq": "x"}},
+		},
+		{
+			name:  "raw MongoDB field",
+			input: map[string]interface{}{"$where": map[string]interface{}{"_eq": "sleep(1000)"}},
+		},
+		{
+			name:  "operator not in the allow-list",
+			input: map[string]interface{}{"name": map[string]interface{}{"_regex": ".*"}},
+		},
+		{
+			name:  "raw MongoDB operator",
+			input: map[string]interface{}{"name": map[string]interface{}{"$where": "sleep(1000)"}},
+		},
+		{
+			name:  "operator not in the allow-list in OR",
+			input: map[string]interface{}{"OR": []interface{}{map[string]interface{}{"age": map[string]interface{}{"$expr": 1}}}},
+		},
+		{
+			name:  "field value which is not an object",
+			input: map[string]interface{}{"name": "A"},
+		},
+		{
+			name:  "text operator on a number",
+			input: map[string]interface{}{"name": map[string]interface{}{"_contains": 1}},
+		},
+		{
+			name:  "_contains and _startsWith together",
+			input: map[string]interface{}{"name": map[string]interface{}{"_contains": "a", "_startsWith": "b"}},
+		},
+		{
+			name:  "OR which is not a list",
+			input: map[string]interface{}{"OR": map[string]interface{}{}},
+		},
+		{
+			name:  "too deep",
+			input: deepFilter,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := BuildMongoFilter(test.input, testFilterFields)
+			var appError *apperror.Error
+			if !errors.As(err, &appError) || appError.Code != apperror.BadUserInput {
+				t.Errorf("got error %v, want a %v error", err, apperror.BadUserInput)
+			}
+		})
+	}
+}
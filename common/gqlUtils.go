@@ -1,14 +1,18 @@
 package common
 
 import (
-	"fmt"
+	"go-graphql-mongo-server/apperror"
 	"go-graphql-mongo-server/models"
 	"regexp"
 
 	"github.com/graphql-go/graphql"
 )
 
-var ErrUnauthorized = fmt.Errorf("unauthorized access: you don't have permission for this operation")
+var (
+	ErrUnauthorized    = apperror.New(apperror.Forbidden, "unauthorized access: you don't have permission for this operation")
+	ErrUnauthenticated = apperror.New(apperror.Unauthenticated, "unauthenticated: please log in to perform this operation")
+	ErrScopedToken     = apperror.New(apperror.Forbidden, "personal access tokens can not be managed with a scoped token")
+)
 
 const userNameRegex = "^[a-zA-Z]{2}\\d{5}$"
 
@@ -16,23 +20,61 @@ func GetUserName(p graphql.ResolveParams) string {
 	return p.Context.Value(models.UserContextKey).(string)
 }
 
-func GetTokenUserName(p graphql.ResolveParams) string {
+// GetTokenUserName returns the owner of the tokens managed by a resolver
+// Users with the tokens:admin permission can manage the tokens of the user given in the userName argument.
+func GetTokenUserName(p graphql.ResolveParams) (string, error) {
 	customUserName, customUserNamePresent := p.Args["userName"].(string)
-	if customUserNamePresent && IsInternalUser(p) && customUserName != "" {
-		return "@" + customUserName + "@"
+	if customUserNamePresent && customUserName != "" {
+		isAdmin, err := HasPermission(p, models.PermissionTokensAdmin)
+		if err != nil {
+			return "", err
+		}
+		if isAdmin {
+			// The tokens of the server identities would carry all their permissions
+			if models.IsReservedUserName(customUserName) {
+				return "", apperror.Newf(apperror.BadUserInput, "the tokens of %v can not be managed", customUserName)
+			}
+			return customUserName, nil
+		}
 	}
-	return p.Context.Value(models.UserContextKey).(string)
+	return p.Context.Value(models.UserContextKey).(string), nil
 }
 
+// IsValidUser reports whether the caller is authenticated, guests are not
 func IsValidUser(p graphql.ResolveParams) bool {
-	return GetUserName(p) != ""
+	userName := GetUserName(p)
+	return userName != "" && userName != models.GuestUser
+}
+
+func IsGuestUser(p graphql.ResolveParams) bool {
+	return GetUserName(p) == models.GuestUser
 }
 
 func IsInternalUser(p graphql.ResolveParams) bool {
 	return GetUserName(p) == models.InternalUser
 }
 
+// GetServiceName returns the name of the calling service, empty when the caller did not use a service key
+func GetServiceName(p graphql.ResolveParams) string {
+	serviceName, _ := p.Context.Value(models.ServiceContextKey).(string)
+	return serviceName
+}
+
+// GetAuthMethod returns how the caller authenticated (Eg. pat, oidc, service_key or mtls)
+func GetAuthMethod(p graphql.ResolveParams) string {
+	authMethod, _ := p.Context.Value(models.AuthMethodContextKey).(string)
+	return authMethod
+}
+
 func GetUserType(p graphql.ResolveParams) string {
+	if GetServiceName(p) != "" {
+		return "Service"
+	}
+
+	if IsGuestUser(p) {
+		return "Guest"
+	}
+
 	isUser, _ := regexp.Match(userNameRegex, []byte(GetUserName(p)))
 	if isUser {
 		return "User"
@@ -44,3 +86,25 @@ func GetUserType(p graphql.ResolveParams) string {
 
 	return "Service"
 }
+
+// GetPageOptions reads the connection arguments (first, after, last, before, sortOrder) of a query
+func GetPageOptions(p graphql.ResolveParams, sortField string) models.PageOptions {
+	pageOptions := models.PageOptions{SortField: sortField}
+	pageOptions.First, _ = p.Args["first"].(int)
+	pageOptions.Last, _ = p.Args["last"].(int)
+	pageOptions.After, _ = p.Args["after"].(string)
+	pageOptions.Before, _ = p.Args["before"].(string)
+	pageOptions.Descending = p.Args["sortOrder"] == "DESC"
+	return pageOptions
+}
+
+// MergeArgs combines multiple argument sets into a single one, later sets override earlier ones
+func MergeArgs(argSets ...graphql.FieldConfigArgument) graphql.FieldConfigArgument {
+	args := graphql.FieldConfigArgument{}
+	for _, argSet := range argSets {
+		for name, arg := range argSet {
+			args[name] = arg
+		}
+	}
+	return args
+}
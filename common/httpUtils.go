@@ -2,14 +2,24 @@ package common
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
 	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"go-graphql-mongo-server/config"
 	"go-graphql-mongo-server/logger"
+	"go-graphql-mongo-server/models"
+	"net"
 	"net/http"
+	"regexp"
+	"strings"
+	"sync"
 )
 
+var requestIDRegex = regexp.MustCompile(`^[a-zA-Z0-9\-_.]{1,64}$`)
+
 func RespondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	response, _ := json.Marshal(payload)
 
@@ -35,6 +45,119 @@ func RespondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 
 }
 
+// WithRequestID attaches a request ID to the request context and the response headers
+// The X-Request-ID header of the request is reused when it looks sane, so that calls can be traced across services.
+// A request which already has a request ID (Eg. set by the auth middleware) is returned as is.
+func WithRequestID(w http.ResponseWriter, r *http.Request) *http.Request {
+	if GetRequestID(r.Context()) != "" {
+		return r
+	}
+
+	requestID := r.Header.Get("X-Request-ID")
+	if !requestIDRegex.MatchString(requestID) {
+		requestID = NewRequestID()
+	}
+	w.Header().Set("X-Request-ID", requestID)
+	return r.WithContext(context.WithValue(r.Context(), models.RequestIDContextKey, requestID))
+}
+
+func NewRequestID() string {
+	requestID := make([]byte, 16)
+	_, _ = rand.Read(requestID)
+	return hex.EncodeToString(requestID)
+}
+
+func GetRequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(models.RequestIDContextKey).(string)
+	return requestID
+}
+
+// WithClientIP attaches the IP address of the client to the request context
+func WithClientIP(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), models.ClientIPContextKey, ClientIP(r)))
+}
+
+// ClientIP returns the IP address of the client of a request
+// X-Forwarded-For is only used when the request comes from one of the TRUSTED_PROXIES. Its addresses are read from the
+// right and the ones of trusted proxies are skipped, as the addresses on the left are chosen by the client.
+func ClientIP(r *http.Request) string {
+	clientIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		clientIP = r.RemoteAddr
+	}
+	if !isTrustedProxy(clientIP) {
+		return clientIP
+	}
+
+	forwardedFor := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwardedFor) - 1; i >= 0; i-- {
+		forwardedIP := strings.TrimSpace(forwardedFor[i])
+		if net.ParseIP(forwardedIP) == nil {
+			break
+		}
+		clientIP = forwardedIP
+		if !isTrustedProxy(forwardedIP) {
+			break
+		}
+	}
+	return clientIP
+}
+
+var initializeTrustedProxies sync.Once
+var trustedProxies []*net.IPNet
+
+func isTrustedProxy(address string) bool {
+	initializeTrustedProxies.Do(func() {
+		trustedProxies = parseTrustedProxies(config.Store.TrustedProxies)
+	})
+
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	for _, trustedProxy := range trustedProxies {
+		if trustedProxy.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Parses the comma separated IP addresses and CIDR ranges of the trusted proxies, invalid entries are ignored
+func parseTrustedProxies(value string) []*net.IPNet {
+	var proxies []*net.IPNet
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil {
+				bits := 8 * len(ip.To16())
+				if ip.To4() != nil {
+					ip, bits = ip.To4(), 32
+				}
+				proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+				continue
+			}
+		}
+
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			logger.Log.Errorf("Invalid trusted proxy %v : %v", entry, err)
+			continue
+		}
+		proxies = append(proxies, ipNet)
+	}
+	return proxies
+}
+
+func GetClientIP(ctx context.Context) string {
+	clientIP, _ := ctx.Value(models.ClientIPContextKey).(string)
+	return clientIP
+}
+
 func RespondWithUnauthorized(w http.ResponseWriter) {
 	RespondWithJSON(w, http.StatusUnauthorized, map[string]string{"message": "Unauthorized"})
 }
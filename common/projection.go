@@ -0,0 +1,97 @@
+package common
+
+import (
+	"strings"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// BuildProjection creates a MongoDB projection containing only the fields requested in the selection set
+// path leads from the resolved field to the document, Eg. ["edges", "node"] for a connection.
+// requiredFields are always included, Eg. the fields needed for a cursor or by the resolver itself.
+// GraphQL field names are expected to be the same as the bson field names.
+func BuildProjection(p graphql.ResolveParams, path []string, requiredFields ...string) bson.M {
+
+	var fields []*ast.Field
+	for _, fieldAST := range p.Info.FieldASTs {
+		if fieldAST.SelectionSet != nil {
+			fields = append(fields, collectFields(p, fieldAST.SelectionSet)...)
+		}
+	}
+
+	for _, name := range path {
+		var children []*ast.Field
+		for _, field := range fields {
+			if field.Name.Value == name && field.SelectionSet != nil {
+				children = append(children, collectFields(p, field.SelectionSet)...)
+			}
+		}
+		fields = children
+	}
+
+	projection := bson.M{}
+	addProjectionFields(p, fields, "", projection)
+	for _, field := range requiredFields {
+		projection[field] = 1
+	}
+
+	// Nested paths collide with their parents in MongoDB, Eg. "address" and "address.city"
+	for field := range projection {
+		for parent := range projection {
+			if strings.HasPrefix(field, parent+".") {
+				delete(projection, field)
+				break
+			}
+		}
+	}
+
+	// An empty projection would return the whole document
+	if len(projection) == 0 {
+		projection["_id"] = 1
+	}
+
+	return projection
+}
+
+func addProjectionFields(p graphql.ResolveParams, fields []*ast.Field, prefix string, projection bson.M) {
+	for _, field := range fields {
+		name := field.Name.Value
+		if strings.HasPrefix(name, "__") {
+			continue
+		}
+
+		if field.SelectionSet == nil {
+			projection[prefix+name] = 1
+			continue
+		}
+
+		// Nested object, Eg. address { city }
+		addProjectionFields(p, collectFields(p, field.SelectionSet), prefix+name+".", projection)
+	}
+}
+
+// Flattens a selection set into its fields, including the fields of fragment spreads and inline fragments
+func collectFields(p graphql.ResolveParams, selectionSet *ast.SelectionSet) []*ast.Field {
+	var fields []*ast.Field
+	for _, selection := range selectionSet.Selections {
+		switch selection := selection.(type) {
+
+		case *ast.Field:
+			fields = append(fields, selection)
+
+		case *ast.InlineFragment:
+			if selection.SelectionSet != nil {
+				fields = append(fields, collectFields(p, selection.SelectionSet)...)
+			}
+
+		case *ast.FragmentSpread:
+			fragment, ok := p.Info.Fragments[selection.Name.Value].(*ast.FragmentDefinition)
+			if ok && fragment.SelectionSet != nil {
+				fields = append(fields, collectFields(p, fragment.SelectionSet)...)
+			}
+		}
+	}
+	return fields
+}
@@ -0,0 +1,76 @@
+package common
+
+import (
+	"go-graphql-mongo-server/config"
+	"go-graphql-mongo-server/models"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+)
+
+// HasPermission reports whether the current user is granted a permission through its roles
+// Requests made with a scoped token only have the permissions which are also in the token scopes.
+func HasPermission(p graphql.ResolveParams, permission string) (bool, error) {
+	if !IsValidUser(p) || !IsInScope(p, permission) {
+		return false, nil
+	}
+	return models.HasPermission(p.Context, GetUserName(p), permission)
+}
+
+// GetScopes returns the scopes of the token of the request, nil when the request is not restricted
+func GetScopes(p graphql.ResolveParams) []string {
+	scopes, _ := p.Context.Value(models.ScopesContextKey).([]string)
+	return scopes
+}
+
+// IsInScope reports whether the token of the request allows a permission
+func IsInScope(p graphql.ResolveParams, permission string) bool {
+	scopes := GetScopes(p)
+	if scopes == nil {
+		return true
+	}
+	for _, scope := range scopes {
+		if scope == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// IsGuestAllowedField reports whether guests may run a field, which is listed as <Type>.<field> in GUEST_ALLOWED_FIELDS
+func IsGuestAllowedField(typeName string, fieldName string) bool {
+	for _, allowed := range strings.Split(config.Store.GuestAllowedFields, ",") {
+		if strings.TrimSpace(allowed) == typeName+"."+fieldName {
+			return true
+		}
+	}
+	return false
+}
+
+// RequirePermission wraps a resolver so that it only runs for users granted the permission
+// Guests don't have any permission, they can only run the fields of the guest allow-list.
+func RequirePermission(permission string, resolve graphql.FieldResolveFn) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+
+		if IsGuestUser(p) {
+			if !IsGuestAllowedField(p.Info.ParentType.Name(), p.Info.FieldName) {
+				return nil, ErrUnauthenticated
+			}
+			return resolve(p)
+		}
+
+		if !IsValidUser(p) {
+			return nil, ErrUnauthenticated
+		}
+
+		allowed, err := HasPermission(p, permission)
+		if err != nil {
+			return nil, err
+		}
+		if !allowed {
+			return nil, ErrUnauthorized
+		}
+
+		return resolve(p)
+	}
+}
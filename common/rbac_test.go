@@ -0,0 +1,72 @@
+package common
+
+import (
+	"context"
+	"go-graphql-mongo-server/models"
+	"testing"
+
+	"github.com/graphql-go/graphql"
+)
+
+func TestIsInScope(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		scopes     []string
+		permission string
+		want       bool
+	}{
+		{name: "unscoped", scopes: nil, permission: models.PermissionRolesAdmin, want: true},
+		{name: "in scope", scopes: []string{models.PermissionUsersRead}, permission: models.PermissionUsersRead, want: true},
+		{name: "out of scope", scopes: []string{models.PermissionUsersRead}, permission: models.PermissionUsersWrite, want: false},
+		{name: "empty scopes", scopes: []string{}, permission: models.PermissionUsersRead, want: false},
+		{
+			name:       "service key scoped to users:read can't write persisted queries",
+			scopes:     []string{models.PermissionUsersRead},
+			permission: models.PermissionPersistedQueriesWrite,
+			want:       false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.WithValue(context.Background(), models.UserContextKey, models.InternalUser)
+			if test.scopes != nil {
+				ctx = context.WithValue(ctx, models.ScopesContextKey, test.scopes)
+			}
+			if got := IsInScope(graphql.ResolveParams{Context: ctx}, test.permission); got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestHasPermissionOfScopedInternalUser(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		scopes     []string
+		permission string
+		want       bool
+	}{
+		{name: "unscoped internal user", scopes: nil, permission: models.PermissionPersistedQueriesWrite, want: true},
+		{name: "scoped internal user", scopes: []string{models.PermissionUsersRead}, permission: models.PermissionUsersRead, want: true},
+		{name: "out of the scopes of the internal user", scopes: []string{models.PermissionUsersRead}, permission: models.PermissionPersistedQueriesWrite, want: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.WithValue(context.Background(), models.UserContextKey, models.InternalUser)
+			if test.scopes != nil {
+				ctx = context.WithValue(ctx, models.ScopesContextKey, test.scopes)
+			}
+			got, err := HasPermission(graphql.ResolveParams{Context: ctx}, test.permission)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
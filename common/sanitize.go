@@ -1,7 +1,7 @@
 package common
 
 import (
-	"fmt"
+	"go-graphql-mongo-server/apperror"
 	"reflect"
 	"sync"
 
@@ -55,19 +55,19 @@ func Sanitize(rawInput interface{}) (interface{}, error) {
 	case reflect.String:
 		rawInputString := rawInput.(string)
 		if len(rawInputString) > maxPermissibleInputStringLength {
-			return rawInput, fmt.Errorf("string length exceeded")
+			return rawInput, apperror.New(apperror.BadUserInput, "string length exceeded")
 		}
 		rawInput = getHTMLSanitizer().Sanitize(rawInputString)
 
 	// For int and float, if number exceeds 5 million, return error
 	case reflect.Int:
 		if rawInput.(int) > maxPermissibleInputNumber {
-			return rawInput, fmt.Errorf("number exceeded")
+			return rawInput, apperror.New(apperror.BadUserInput, "number exceeded")
 		}
 
 	case reflect.Float64:
 		if rawInput.(float64) > maxPermissibleInputNumber {
-			return rawInput, fmt.Errorf("floating point number exceeded")
+			return rawInput, apperror.New(apperror.BadUserInput, "floating point number exceeded")
 		}
 
 	}
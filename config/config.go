@@ -1,11 +1,21 @@
 package config
 
-import "github.com/adammck/venv"
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/adammck/venv"
+)
 
 type Configurations struct {
 	Database
 	Auth
 	HTTPSCert
+	QueryLimits
 	ProductionMode    bool
 	CORSAllowOrigins  string
 	ServicePort       string
@@ -14,6 +24,50 @@ type Configurations struct {
 	PlatformName      string
 	Env               string
 	ComponentName     string
+
+	// Comma separated IP addresses or CIDR ranges (Eg. 10.0.0.0/8) of the proxies allowed to set X-Forwarded-For
+	TrustedProxies string
+
+	// Duration (Eg. 720h) for which soft deleted users are kept before being purged
+	UserRetentionPeriod string
+
+	// Only allow operations registered in the persisted query safelist
+	PersistedQueriesOnly bool
+
+	// Duration (Eg. 720h) for which the documents stored by Automatic Persisted Queries are kept
+	PersistedQueryTTL string
+
+	// Maximum number of operations in a batched request, and how many of them are executed concurrently
+	MaxBatchSize int
+	BatchWorkers int
+
+	// Duration (Eg. 30s) after which a single GraphQL operation is aborted
+	OperationTimeout string
+
+	// Comma separated roles granted to every authenticated user
+	DefaultRoles string
+
+	// Interval (Eg. 1m) at which the usage of personal access tokens is written to the database
+	TokenUsageFlushInterval string
+
+	// Maximum lifetime (Eg. 8760h) of a personal access token, and how long before expiry it is flagged as expiring soon
+	MaxTokenLifetime   string
+	TokenExpiryWarning string
+
+	// Maximum duration (Eg. 24h) for which the old secret of a rotated token stays valid
+	TokenRotationGracePeriod string
+
+	JWKSMinRefreshInterval string
+
+	// Unauthenticated requests get the guest identity, which can only run the allowed root fields (Eg. Query.Users)
+	// The allowed fields skip the permission checks, whatever the roles are.
+	GuestEnabled            bool
+	GuestAllowedFields      string
+	GuestAPILimitPerSecond  string
+	GuestMaxQueryComplexity int
+
+	// Duration (Eg. 2160h) for which the audit log entries are kept, they are kept forever when empty or 0
+	AuditLogRetention string
 }
 
 // Database configuration
@@ -27,23 +81,75 @@ type Database struct {
 }
 
 type Auth struct {
-	// In House Token related configs
+	// In House Token related configs, the private key can contain multiple PEM encoded keys
 	JWTInHousePrivateKey string
+	JWTActiveKeyID       string
+	JWTIssuer            string
 
-	// Internal auth config
+	// Expose the RFC 7662 introspection endpoint for the in-house tokens
+	IntrospectionEnabled bool
+
+	// Token sent to the telemetry service, other services call this server with service keys
 	SecretToken string
 
+	// Service key with every permission accepted without being stored, to create the first service keys and roles
+	// It must start with "sk_" and be at least 32 characters long, it should be removed once the setup is done.
+	BootstrapServiceKey string
+
+	// Comma separated scopes given to the new service keys created without scopes
+	ServiceKeyDefaultScopes string
+
 	// OIDC related configs
 	OidcEnabled  bool
 	OidcURL      string
 	ClientID     string
 	ClientSecret string
+	OidcIssuers  []OIDCIssuer
+}
+
+// A trusted OIDC issuer, configured as a JSON array in OIDC_ISSUERS
+// OIDC_URL and CLIENT_ID configure a single issuer when OIDC_ISSUERS is not set.
+type OIDCIssuer struct {
+	Issuer    string   `json:"issuer"`
+	Audiences []string `json:"audiences"`
+
+	// Optional, it is discovered from the issuer when empty
+	JwksURI string `json:"jwksUri"`
+
+	// Claim used as user name, "sub" by default
+	UsernameClaim string `json:"usernameClaim"`
+}
+
+// Limits applied on every GraphQL operation before it is executed
+type QueryLimits struct {
+	MaxQueryDepth      int
+	MaxQueryAliases    int
+	MaxQueryComplexity int
 }
 
 type HTTPSCert struct {
 	HTTPSEnabled bool
 	CertFilePath string
 	KeyFilePath  string
+
+	// Client certificates (mTLS) are verified with the CA bundle, ClientAuth is "optional" or "required"
+	ClientCAFilePath     string
+	ClientAuth           string
+	ClientCertIdentities []ClientCertIdentity
+}
+
+// An accepted client certificate, configured as a JSON array in HTTPS_CLIENT_CERT_IDENTITIES
+// A certificate matches when it has the Subject and the SAN which are set, it is mapped to a user or to a service.
+type ClientCertIdentity struct {
+	// Eg. "CN=billing,O=Acme" or "CN=billing"
+	Subject string `json:"subject"`
+
+	// Eg. "DNS:billing.internal", "URI:spiffe://acme/billing" or "EMAIL:ops@acme.com"
+	SAN string `json:"san"`
+
+	UserName string   `json:"userName"`
+	Service  string   `json:"service"`
+	Scopes   []string `json:"scopes"`
 }
 
 var env venv.Env
@@ -57,7 +163,7 @@ func InitializeConfig(e venv.Env) {
 
 func readConfigValues() Configurations {
 	//nolint:goconst
-	return Configurations{
+	configurations := Configurations{
 		Database: Database{
 			Host:               getEnvVariable("DB_HOST", ""),
 			Port:               getEnvVariable("DB_PORT", ""),
@@ -68,16 +174,29 @@ func readConfigValues() Configurations {
 		},
 		Auth: Auth{
 			JWTInHousePrivateKey: getEnvVariable("JWT_PRIVATE_KEY", ""),
+			JWTActiveKeyID:       getEnvVariable("JWT_ACTIVE_KEY_ID", ""),
+			JWTIssuer:            getEnvVariable("JWT_ISSUER", ""),
+			IntrospectionEnabled: getEnvVariable("INTROSPECTION_ENABLED", "false") == "true",
 			SecretToken:          getEnvVariable("SECRET_TOKEN", ""),
 			OidcURL:              getEnvVariable("OIDC_URL", ""),
-			OidcEnabled:          getEnvVariable("OIDC_URL", "") != "",
 			ClientID:             getEnvVariable("CLIENT_ID", ""),
 			ClientSecret:         getEnvVariable("CLIENT_SECRET", ""),
+
+			BootstrapServiceKey:     getBootstrapServiceKey(),
+			ServiceKeyDefaultScopes: getEnvVariable("SERVICE_KEY_DEFAULT_SCOPES", "users:read"),
 		},
 		HTTPSCert: HTTPSCert{
 			CertFilePath: getEnvVariable("HTTPS_CERT_FILE_PATH", ""),
 			KeyFilePath:  getEnvVariable("HTTPS_KEY_FILE_PATH", ""),
 			HTTPSEnabled: getEnvVariable("HTTPS_CERT_FILE_PATH", "") != "",
+
+			ClientCAFilePath: getEnvVariable("HTTPS_CLIENT_CA_FILE_PATH", ""),
+			ClientAuth:       getEnvVariable("HTTPS_CLIENT_AUTH", "optional"),
+		},
+		QueryLimits: QueryLimits{
+			MaxQueryDepth:      getEnvVariableInt("MAX_QUERY_DEPTH", 10),
+			MaxQueryAliases:    getEnvVariableInt("MAX_QUERY_ALIASES", 30),
+			MaxQueryComplexity: getEnvVariableInt("MAX_QUERY_COMPLEXITY", 10000),
 		},
 		ProductionMode:    getEnvVariable("PRODUCTION_MODE", "true") == "true",
 		CORSAllowOrigins:  getEnvVariable("CORS_ALLOW_ORIGINS", ""),
@@ -87,6 +206,89 @@ func readConfigValues() Configurations {
 		PlatformName:      getEnvVariable("PLATFORM_NAME", "Ani Platform"),
 		Env:               getEnvVariable("ENV", ""),
 		ComponentName:     getEnvVariable("COMPONENT_NAME", "Go GraphQl Mongo Server"),
+		TrustedProxies:    getEnvVariable("TRUSTED_PROXIES", ""),
+
+		UserRetentionPeriod:  getEnvVariable("USER_RETENTION_PERIOD", "720h"),
+		PersistedQueriesOnly: getEnvVariable("PERSISTED_QUERIES_ONLY", "false") == "true",
+		PersistedQueryTTL:    getEnvVariable("PERSISTED_QUERY_TTL", "720h"),
+		MaxBatchSize:         getEnvVariableInt("MAX_BATCH_SIZE", 20),
+		BatchWorkers:         getEnvVariableInt("BATCH_WORKERS", 4),
+		OperationTimeout:     getEnvVariable("OPERATION_TIMEOUT", "30s"),
+		DefaultRoles:         getEnvVariable("DEFAULT_ROLES", "viewer"),
+
+		TokenUsageFlushInterval: getEnvVariable("TOKEN_USAGE_FLUSH_INTERVAL", "1m"),
+		MaxTokenLifetime:        getEnvVariable("MAX_TOKEN_LIFETIME", "8760h"),
+		TokenExpiryWarning:      getEnvVariable("TOKEN_EXPIRY_WARNING", "168h"),
+
+		TokenRotationGracePeriod: getEnvVariable("TOKEN_ROTATION_GRACE_PERIOD", "24h"),
+
+		JWKSMinRefreshInterval: getEnvVariable("JWKS_MIN_REFRESH_INTERVAL", "1m"),
+
+		GuestEnabled:            getEnvVariable("GUEST_ENABLED", "false") == "true",
+		GuestAllowedFields:      getEnvVariable("GUEST_ALLOWED_FIELDS", ""),
+		GuestAPILimitPerSecond:  getEnvVariable("GUEST_API_LIMIT_PER_SECOND", "50"),
+		GuestMaxQueryComplexity: getEnvVariableInt("GUEST_MAX_QUERY_COMPLEXITY", 1000),
+
+		AuditLogRetention: getEnvVariable("AUDIT_LOG_RETENTION", "2160h"),
+	}
+
+	configurations.OidcIssuers = getOidcIssuers(configurations.Auth)
+	getEnvVariableJSON("HTTPS_CLIENT_CERT_IDENTITIES", &configurations.ClientCertIdentities)
+	configurations.OidcEnabled = len(configurations.OidcIssuers) > 0
+	return configurations
+}
+
+// Reads the trusted OIDC issuers from OIDC_ISSUERS, or from OIDC_URL and CLIENT_ID
+func getOidcIssuers(auth Auth) []OIDCIssuer {
+	var issuers []OIDCIssuer
+
+	if env.Getenv("OIDC_ISSUERS") != "" {
+		getEnvVariableJSON("OIDC_ISSUERS", &issuers)
+	} else if auth.OidcURL != "" {
+		issuers = append(issuers, OIDCIssuer{Issuer: auth.OidcURL, Audiences: []string{auth.ClientID}})
+	}
+
+	for i := range issuers {
+		if issuers[i].UsernameClaim == "" {
+			issuers[i].UsernameClaim = "sub"
+		}
+	}
+	return issuers
+}
+
+// Minimum length of BOOTSTRAP_SERVICE_KEY, prefix included
+const minBootstrapServiceKeyLength = 32
+
+// Reads BOOTSTRAP_SERVICE_KEY, which is ignored when it does not look like a service key or is too short to be safe
+func getBootstrapServiceKey() string {
+	key := env.Getenv("BOOTSTRAP_SERVICE_KEY")
+	if key == "" {
+		return ""
+	}
+
+	if !strings.HasPrefix(key, "sk_") || len(key) < minBootstrapServiceKeyLength {
+		// The logger is not initialized yet
+		fmt.Fprintf(os.Stderr, "Invalid BOOTSTRAP_SERVICE_KEY: it must start with sk_ and be at least %v characters long\n", minBootstrapServiceKeyLength)
+		return ""
+	}
+	return key
+}
+
+// Decodes a JSON environment variable into the value pointed to, which is reset when the variable is invalid
+func getEnvVariableJSON(key string, value interface{}) {
+	raw := env.Getenv(key)
+	if raw == "" {
+		return
+	}
+
+	err := json.Unmarshal([]byte(raw), value)
+	if err != nil {
+		// The logger is not initialized yet
+		fmt.Fprintf(os.Stderr, "Invalid %v: %v\n", key, err)
+
+		// A partially decoded value must not be used
+		target := reflect.ValueOf(value).Elem()
+		target.Set(reflect.Zero(target.Type()))
 	}
 }
 
@@ -97,3 +299,11 @@ func getEnvVariable(key string, defaultValue string) string {
 	}
 	return value
 }
+
+func getEnvVariableInt(key string, defaultValue int) int {
+	value, err := strconv.Atoi(env.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,111 @@
+package gqlhandler
+
+import (
+	"context"
+	"errors"
+	"go-graphql-mongo-server/apperror"
+	"go-graphql-mongo-server/config"
+	"go-graphql-mongo-server/logger"
+	"go-graphql-mongo-server/models"
+	"sync"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+// Executes the operations of a batch which don't have a result yet, at most config.Store.BatchWorkers at a time
+// Queries are executed concurrently, while mutations may depend on each other and are executed one after the other
+// in the order of the batch. Every result is stored at the index of its request, so the results keep the order of the batch.
+func executeOperations(ctx context.Context, requests []models.GQLRequestBody, results []*graphql.Result) {
+
+	workers := config.Store.BatchWorkers
+	if workers < 1 {
+		workers = 1
+	}
+
+	semaphore := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+
+	var queries, mutations []int
+	for i := range requests {
+		if results[i] != nil {
+			continue
+		}
+		if getOperationType(requests[i]) == ast.OperationTypeMutation {
+			mutations = append(mutations, i)
+		} else {
+			queries = append(queries, i)
+		}
+	}
+
+	if len(mutations) > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, i := range mutations {
+				semaphore <- struct{}{}
+				results[i] = executeOperation(ctx, requests[i])
+				<-semaphore
+			}
+		}()
+	}
+
+	for _, i := range queries {
+		semaphore <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+			results[i] = executeOperation(ctx, requests[i])
+		}(i)
+	}
+
+	wg.Wait()
+}
+
+// Executes a single operation with its own timeout, derived from the request context
+func executeOperation(ctx context.Context, request models.GQLRequestBody) *graphql.Result {
+
+	timeout := operationTimeout()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	result := graphql.Do(graphql.Params{
+		Schema:         SchemaQl,
+		RequestString:  request.Query,
+		VariableValues: request.Variables,
+		OperationName:  request.OperationName,
+		Context:        ctx,
+	})
+
+	// graphql-go gives up on the operation when its context is done, and only returns the context error
+	if result.Data == nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		timeoutErr := apperror.Newf(apperror.Timeout, "operation timed out after %v", timeout)
+		result.Errors = []gqlerrors.FormattedError{{
+			Message:    timeoutErr.Error(),
+			Extensions: timeoutErr.Extensions(),
+		}}
+	}
+
+	return result
+}
+
+func operationTimeout() time.Duration {
+	if config.Store.OperationTimeout == "" {
+		return 0
+	}
+
+	timeout, err := time.ParseDuration(config.Store.OperationTimeout)
+	if err != nil {
+		logger.Log.Errorf("Invalid operation timeout %v : %v", config.Store.OperationTimeout, err)
+		return 0
+	}
+	return timeout
+}
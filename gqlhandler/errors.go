@@ -0,0 +1,64 @@
+package gqlhandler
+
+import (
+	"go-graphql-mongo-server/apperror"
+	"go-graphql-mongo-server/config"
+	"go-graphql-mongo-server/logger"
+
+	"github.com/graphql-go/graphql/gqlerrors"
+)
+
+const maskedErrorMessage = "internal server error"
+
+// formatErrors gives a code to every error of a result and hides the details of internal errors in production
+// Internal errors are logged with the request ID, which is sent to the client as correlationId.
+func formatErrors(requestID string, formattedErrors []gqlerrors.FormattedError) {
+
+	for i := range formattedErrors {
+		formattedError := &formattedErrors[i]
+
+		// Errors raised by the handler itself and the errors of resolvers implementing gqlerrors.ExtendedError already
+		// have a code, internal errors still have to be masked
+		if code, hasCode := formattedError.Extensions["code"]; hasCode {
+			if code == string(apperror.Internal) {
+				maskInternalError(requestID, formattedError, originalError(*formattedError))
+			}
+			continue
+		}
+
+		// Errors without a path are request errors (Eg. syntax or validation), resolvers always have a path
+		if len(formattedError.Path) == 0 {
+			formattedError.Extensions = map[string]interface{}{"code": string(apperror.BadUserInput)}
+			continue
+		}
+
+		appError := apperror.From(originalError(*formattedError))
+		formattedError.Message = appError.Error()
+		formattedError.Extensions = appError.Extensions()
+
+		if appError.Code == apperror.Internal {
+			maskInternalError(requestID, formattedError, appError.Err)
+		}
+	}
+}
+
+// Logs an internal error with the request ID, which is sent to the client as correlationId, and hides its details in production
+func maskInternalError(requestID string, formattedError *gqlerrors.FormattedError, err error) {
+	logger.Log.Errorf("[GraphQl] internal error (correlationId: %v) at %v : %+v", requestID, formattedError.Path, err)
+	formattedError.Extensions["correlationId"] = requestID
+	if config.Store.ProductionMode {
+		formattedError.Message = maskedErrorMessage
+	}
+}
+
+// Returns the error returned by the resolver, graphql-go wraps it into a located error
+func originalError(formattedError gqlerrors.FormattedError) error {
+	err := formattedError.OriginalError()
+	if locatedError, ok := err.(*gqlerrors.Error); ok && locatedError.OriginalError != nil {
+		return locatedError.OriginalError
+	}
+	if err == nil {
+		return formattedError
+	}
+	return err
+}
@@ -2,72 +2,186 @@ package gqlhandler
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"go-graphql-mongo-server/common"
 	"go-graphql-mongo-server/config"
 	"go-graphql-mongo-server/gqlhandler/mutation"
 	"go-graphql-mongo-server/gqlhandler/query"
+	"go-graphql-mongo-server/gqlhandler/subscription"
 	"go-graphql-mongo-server/logger"
 	"go-graphql-mongo-server/models"
 	"io"
 	"net/http"
+	"net/url"
+	"strings"
 
 	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
 )
 
 var SchemaQl, _ = graphql.NewSchema(graphql.SchemaConfig{
-	Query:    rootQuery,
-	Mutation: rootMutation,
+	Query:        rootQuery,
+	Mutation:     rootMutation,
+	Subscription: rootSubscription,
 })
 
 var mutationMap = graphql.Fields{
-	mutation.UserMutation.Name:        mutation.UserMutation,
-	mutation.CreateTokenMutation.Name: mutation.CreateTokenMutation,
-	mutation.RevokeTokenMutation.Name: mutation.RevokeTokenMutation,
+	mutation.UserMutation.Name:         mutation.UserMutation,
+	mutation.UpdateUserMutation.Name:   mutation.UpdateUserMutation,
+	mutation.PatchUserMutation.Name:    mutation.PatchUserMutation,
+	mutation.DeleteUsersMutation.Name:  mutation.DeleteUsersMutation,
+	mutation.RestoreUsersMutation.Name: mutation.RestoreUsersMutation,
+	mutation.CreateTokenMutation.Name:  mutation.CreateTokenMutation,
+	mutation.RevokeTokenMutation.Name:  mutation.RevokeTokenMutation,
+	mutation.RotateTokenMutation.Name:  mutation.RotateTokenMutation,
+	mutation.GrantRoleMutation.Name:    mutation.GrantRoleMutation,
+	mutation.RevokeRoleMutation.Name:   mutation.RevokeRoleMutation,
+
+	mutation.CreateServiceKeyMutation.Name: mutation.CreateServiceKeyMutation,
+	mutation.UpdateServiceKeyMutation.Name: mutation.UpdateServiceKeyMutation,
+	mutation.RotateServiceKeyMutation.Name: mutation.RotateServiceKeyMutation,
+	mutation.DeleteServiceKeyMutation.Name: mutation.DeleteServiceKeyMutation,
+
+	mutation.RegisterPersistedQueriesMutation.Name: mutation.RegisterPersistedQueriesMutation,
 }
 var queryMap = graphql.Fields{
 	query.UsersQuery.Name: query.UsersQuery,
 	query.TokenQuery.Name: query.TokenQuery,
+
+	query.UnusedTokensQuery.Name: query.UnusedTokensQuery,
+	query.ServiceKeysQuery.Name:  query.ServiceKeysQuery,
+	query.AuditLogQuery.Name:     query.AuditLogQuery,
+}
+var subscriptionMap = graphql.Fields{
+	subscription.UserChangedSubscription.Name: subscription.UserChangedSubscription,
 }
 
 var rootMutation = graphql.NewObject(graphql.ObjectConfig{
 	Name:   "Mutation",
-	Fields: mutationMap,
+	Fields: auditMutations(mutationMap),
 })
 var rootQuery = graphql.NewObject(graphql.ObjectConfig{
 	Name:   "Query",
 	Fields: queryMap,
 })
+var rootSubscription = graphql.NewObject(graphql.ObjectConfig{
+	Name:   "Subscription",
+	Fields: subscriptionMap,
+})
+
+// Every mutation is recorded in the audit log, so that new mutations can't be missed
+func auditMutations(fields graphql.Fields) graphql.Fields {
+	for _, field := range fields {
+		field.Resolve = common.AuditMutation(field.Resolve)
+	}
+	return fields
+}
+
+const (
+	contentTypeJSON            = "application/json"
+	contentTypeGraphQLResponse = "application/graphql-response+json"
+)
 
 func GraphqlHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	ctx := r.Context()
-	queryBody, err := io.ReadAll(r.Body)
-	if err != nil {
-		handleError("Error in reading request body", err, w)
+	contentType, acceptable := negotiateContentType(r.Header.Get("Accept"))
+	if !acceptable {
+		handleError("Error in negotiating content type", errors.New("accepted media types are not supported"), http.StatusNotAcceptable, w)
 		return
 	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Add("Vary", "Accept")
 
-	requests, err := getRequest(queryBody)
-	if err != nil {
-		handleError("Error in parsing request body", err, w)
+	// Set HSTS header is HTTPS is enabled
+	if config.Store.HTTPSCert.HTTPSEnabled {
+		w.Header().Set("Strict-Transport-Security", "max-age=31536000; includeSubDomains")
+	}
+
+	r = common.WithRequestID(w, r)
+	requestID := common.GetRequestID(r.Context())
+
+	// Documents read by the loaders are shared by all the operations of the request
+	ctx := models.WithLoaders(r.Context())
+
+	var requests []models.GQLRequestBody
+	var err error
+
+	if r.Method == http.MethodGet {
+		requests, err = getRequestFromURL(r.URL.Query())
+		if err != nil {
+			handleError("Error in parsing request query parameters", err, http.StatusBadRequest, w)
+			return
+		}
+	} else {
+		queryBody, err := io.ReadAll(r.Body)
+		if err != nil {
+			handleError("Error in reading request body", err, http.StatusBadRequest, w)
+			return
+		}
+
+		requests, err = getRequest(queryBody)
+		if err != nil {
+			handleError("Error in parsing request body", err, http.StatusBadRequest, w)
+			return
+		}
+	}
+
+	if config.Store.MaxBatchSize > 0 && len(requests) > config.Store.MaxBatchSize {
+		handleError("Error in executing batched request", fmt.Errorf("a batch can contain at most %d operations", config.Store.MaxBatchSize), http.StatusBadRequest, w)
 		return
 	}
 
-	var resultMap []*graphql.Result
+	resultMap := make([]*graphql.Result, len(requests))
+
+	// The complexity budget is shared by all the operations of a batch, so they are checked in order
+	maxComplexity := getComplexityBudget(ctx)
+	complexityBudget := maxComplexity
+	for i := range requests {
+		request := &requests[i]
+
+		newPersistedQuery, persistedQueryErr := resolvePersistedQuery(ctx, request)
+		if persistedQueryErr != nil {
+			resultMap[i] = &graphql.Result{Errors: []gqlerrors.FormattedError{persistedQueryErr.formatted()}}
+			continue
+		}
+
+		// GET requests must be safe, as they may be cached or prefetched, so they never store a persisted query
+		if r.Method == http.MethodGet && getOperationType(*request) != ast.OperationTypeQuery {
+			w.Header().Set("Allow", http.MethodPost)
+			handleError("Error in executing GET request", errors.New("only queries can be executed with GET requests"), http.StatusMethodNotAllowed, w)
+			return
+		}
+
+		if guestErr := checkGuestAccess(ctx, *request); guestErr != nil {
+			resultMap[i] = &graphql.Result{Errors: []gqlerrors.FormattedError{*guestErr}}
+			continue
+		}
+
+		// Rejected operations are not executed, so they don't use up the budget
+		cost, limitErr := checkQueryLimits(*request, maxComplexity, complexityBudget)
+		if limitErr != nil {
+			resultMap[i] = &graphql.Result{Errors: []gqlerrors.FormattedError{limitErr.formatted()}}
+			continue
+		}
+		complexityBudget -= cost
+
+		// Only the operations passing every check are stored, guests can't store persisted queries
+		if newPersistedQuery != nil && r.Method != http.MethodGet && !isGuest(ctx) {
+			storePersistedQuery(ctx, *newPersistedQuery)
+		}
+	}
+
+	// The operations which passed the checks are executed concurrently
+	executeOperations(ctx, requests, resultMap)
+
 	var errorCount int
-	for _, request := range requests {
-		result := graphql.Do(graphql.Params{
-			Schema:         SchemaQl,
-			RequestString:  request.Query,
-			VariableValues: request.Variables,
-			Context:        ctx,
-		})
-
-		resultMap = append(resultMap, result)
+	for _, result := range resultMap {
+		formatErrors(requestID, result.Errors)
 		if result.HasErrors() {
 			errorCount++
 		}
-
 	}
 
 	if len(requests) == errorCount {
@@ -84,11 +198,6 @@ func GraphqlHandler(w http.ResponseWriter, r *http.Request) {
 			response, _ = json.Marshal(resultMap)
 		}
 
-		// Set HSTS header is HTTPS is enabled
-		if config.Store.HTTPSCert.HTTPSEnabled {
-			w.Header().Set("Strict-Transport-Security", "max-age=31536000; includeSubDomains")
-		}
-
 		_, err = w.Write(response)
 		if err != nil {
 			logger.Log.Errorf("Error in writing response %+v", err)
@@ -100,34 +209,126 @@ func GraphqlHandler(w http.ResponseWriter, r *http.Request) {
 func getRequest(queryBody []byte) ([]models.GQLRequestBody, error) {
 	var requests []models.GQLRequestBody
 	var err error
-	queryBodyString := string(queryBody)
+	queryBodyString := strings.TrimSpace(string(queryBody))
+	if queryBodyString == "" {
+		return nil, errors.New("empty request body")
+	}
 	if queryBodyString[0] == '[' {
 		err = json.Unmarshal(queryBody, &requests)
 		if err != nil {
 			return nil, err
 		}
 	} else {
-		jsonMap := make(map[string]interface{})
+		var request models.GQLRequestBody
 
-		err = json.Unmarshal(queryBody, &jsonMap)
+		err = json.Unmarshal(queryBody, &request)
 		if err != nil {
 			return nil, err
 		}
-		variables := make(map[string]interface{})
-		if jsonMap["variables"] != nil {
-			variables = jsonMap["variables"].(map[string]interface{})
+		if request.Variables == nil {
+			request.Variables = make(map[string]interface{})
 		}
 
-		requests = append(requests, models.GQLRequestBody{
-			Query:     jsonMap["query"].(string),
-			Variables: variables,
-		})
+		requests = append(requests, request)
 	}
 
 	return requests, nil
 }
 
-func handleError(text string, err error, w http.ResponseWriter) {
+// getRequestFromURL reads a request from the URL query parameters of a GET request
+// variables and extensions are JSON encoded, Eg. ?query=...&variables={"id":1}&operationName=GetUser
+func getRequestFromURL(values url.Values) ([]models.GQLRequestBody, error) {
+	request := models.GQLRequestBody{
+		Query:         values.Get("query"),
+		OperationName: values.Get("operationName"),
+		Variables:     make(map[string]interface{}),
+	}
+
+	if variables := values.Get("variables"); variables != "" {
+		if err := json.Unmarshal([]byte(variables), &request.Variables); err != nil {
+			return nil, fmt.Errorf("invalid variables: %v", err)
+		}
+	}
+
+	if extensions := values.Get("extensions"); extensions != "" {
+		if err := json.Unmarshal([]byte(extensions), &request.Extensions); err != nil {
+			return nil, fmt.Errorf("invalid extensions: %v", err)
+		}
+	}
+
+	if request.Query == "" && request.Extensions.PersistedQuery == nil {
+		return nil, errors.New("query is missing")
+	}
+
+	return []models.GQLRequestBody{request}, nil
+}
+
+// getOperationType returns the type (query, mutation or subscription) of the operation which will be executed
+// Documents which can not be parsed return an empty string, graphql.Do will report the error.
+func getOperationType(request models.GQLRequestBody) string {
+	document, err := parser.Parse(parser.ParseParams{Source: request.Query})
+	if err != nil {
+		return ""
+	}
+
+	var operationType string
+	var operationCount int
+	for _, definition := range document.Definitions {
+		operation, ok := definition.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		operationCount++
+		if request.OperationName == "" || (operation.Name != nil && operation.Name.Value == request.OperationName) {
+			operationType = operation.Operation
+		}
+	}
+
+	// Without an operationName, the document must contain a single operation
+	if request.OperationName == "" && operationCount > 1 {
+		return ""
+	}
+	return operationType
+}
+
+// negotiateContentType picks the response media type from the Accept header of the request
+// application/graphql-response+json is preferred, application/json is used for legacy clients.
+func negotiateContentType(accept string) (string, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return contentTypeJSON, true
+	}
+
+	var acceptsJSON bool
+	for _, mediaRange := range strings.Split(accept, ",") {
+		parameters := strings.Split(mediaRange, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(parameters[0]))
+
+		// Skip media types explicitly refused with q=0
+		isRefused := false
+		for _, parameter := range parameters[1:] {
+			parameter = strings.ReplaceAll(strings.TrimSpace(parameter), " ", "")
+			if parameter == "q=0" || parameter == "q=0.0" || parameter == "q=0.00" || parameter == "q=0.000" {
+				isRefused = true
+			}
+		}
+		if isRefused {
+			continue
+		}
+
+		switch mediaType {
+		case contentTypeGraphQLResponse:
+			return contentTypeGraphQLResponse, true
+		case contentTypeJSON, "application/*", "*/*":
+			acceptsJSON = true
+		}
+	}
+
+	return contentTypeJSON, acceptsJSON
+}
+
+func handleError(text string, err error, statusCode int, w http.ResponseWriter) {
 	logger.Log.Errorf("%v : %+v", text, err)
-	common.RespondWithJSON(w, http.StatusBadRequest, `{"errors": [{"message": "`+err.Error()+`"}]}`)
+	common.RespondWithJSON(w, statusCode, map[string]interface{}{
+		"errors": []map[string]string{{"message": err.Error()}},
+	})
 }
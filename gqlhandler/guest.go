@@ -0,0 +1,106 @@
+package gqlhandler
+
+import (
+	"context"
+	"go-graphql-mongo-server/apperror"
+	"go-graphql-mongo-server/common"
+	"go-graphql-mongo-server/config"
+	"go-graphql-mongo-server/models"
+	"strings"
+
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+)
+
+func isGuest(ctx context.Context) bool {
+	return ctx.Value(models.UserContextKey) == models.GuestUser
+}
+
+// Returns the complexity budget of a request, guests have their own budget
+func getComplexityBudget(ctx context.Context) int {
+	if isGuest(ctx) {
+		return config.Store.GuestMaxQueryComplexity
+	}
+	return config.Store.MaxQueryComplexity
+}
+
+// checkGuestAccess rejects the operations of guests which select a root field missing from the guest allow-list
+// Documents which can not be parsed are left to graphql.Do to report.
+func checkGuestAccess(ctx context.Context, request models.GQLRequestBody) *gqlerrors.FormattedError {
+
+	if !isGuest(ctx) {
+		return nil
+	}
+
+	document, err := parser.Parse(parser.ParseParams{Source: request.Query})
+	if err != nil {
+		return nil
+	}
+
+	fragments := map[string]*ast.FragmentDefinition{}
+	for _, definition := range document.Definitions {
+		if fragment, ok := definition.(*ast.FragmentDefinition); ok {
+			fragments[fragment.Name.Value] = fragment
+		}
+	}
+
+	for _, definition := range document.Definitions {
+		operation, ok := definition.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		if request.OperationName != "" && (operation.Name == nil || operation.Name.Value != request.OperationName) {
+			continue
+		}
+
+		typeName := rootTypeName(operation.Operation)
+		for _, fieldName := range rootFieldNames(operation.SelectionSet, fragments, map[string]bool{}) {
+			if !common.IsGuestAllowedField(typeName, fieldName) {
+				formattedError := gqlerrors.FormatError(apperror.Newf(apperror.Unauthenticated, "%v.%v is not available to guests, please log in", typeName, fieldName))
+				return &formattedError
+			}
+		}
+	}
+	return nil
+}
+
+func rootTypeName(operation string) string {
+	switch operation {
+	case ast.OperationTypeMutation:
+		return SchemaQl.MutationType().Name()
+	case ast.OperationTypeSubscription:
+		return SchemaQl.SubscriptionType().Name()
+	default:
+		return SchemaQl.QueryType().Name()
+	}
+}
+
+// Returns the names of the fields of a selection set, including the fields selected by its fragments
+// Introspection fields are left out, so that GraphiQl keeps working.
+func rootFieldNames(selectionSet *ast.SelectionSet, fragments map[string]*ast.FragmentDefinition, visiting map[string]bool) []string {
+
+	if selectionSet == nil {
+		return nil
+	}
+
+	var names []string
+	for _, selection := range selectionSet.Selections {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			if !strings.HasPrefix(selection.Name.Value, "__") {
+				names = append(names, selection.Name.Value)
+			}
+		case *ast.InlineFragment:
+			names = append(names, rootFieldNames(selection.SelectionSet, fragments, visiting)...)
+		case *ast.FragmentSpread:
+			fragment, found := fragments[selection.Name.Value]
+			if !found || visiting[selection.Name.Value] {
+				continue
+			}
+			visiting[selection.Name.Value] = true
+			names = append(names, rootFieldNames(fragment.SelectionSet, fragments, visiting)...)
+		}
+	}
+	return names
+}
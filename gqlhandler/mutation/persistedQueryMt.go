@@ -0,0 +1,87 @@
+package mutation
+
+import (
+	"go-graphql-mongo-server/apperror"
+	"go-graphql-mongo-server/common"
+	"go-graphql-mongo-server/gqlhandler/schema"
+	"go-graphql-mongo-server/models"
+	"go-graphql-mongo-server/telemetry"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/parser"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var RegisterPersistedQueriesMutation = &graphql.Field{
+	Name:        "RegisterPersistedQueries",
+	Type:        graphql.NewList(schema.PersistedQuerySchema),
+	Description: "Register an operation manifest in the persisted query safelist",
+	Args: graphql.FieldConfigArgument{
+		"manifest": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(schema.PersistedQueryInputSchema))),
+		},
+	},
+	Resolve: common.RequirePermission(models.PermissionPersistedQueriesWrite, func(p graphql.ResolveParams) (i interface{}, e error) {
+
+		// The documents are not sanitized, as HTML escaping would change their hash.
+		// Instead each of them must be a valid GraphQL document.
+		defer telemetry.LogGraphQlCall(p, e)
+
+		manifest, _ := p.Args["manifest"].([]interface{})
+		persistedQueries := make([]models.PersistedQuery, 0, len(manifest))
+		var writeModels []mongo.WriteModel
+
+		for _, item := range manifest {
+			input, _ := item.(map[string]interface{})
+			query, _ := input["query"].(string)
+			operationName, _ := input["operationName"].(string)
+
+			if len(query) > models.MaxPersistedQueryLength {
+				return nil, apperror.New(apperror.BadUserInput, "query length exceeded")
+			}
+			if _, err := parser.Parse(parser.ParseParams{Source: query}); err != nil {
+				return nil, apperror.Newf(apperror.BadUserInput, "invalid query: %v", err)
+			}
+
+			hash := models.PersistedQueryHash(query)
+			if providedHash, _ := input["sha256Hash"].(string); providedHash != "" && providedHash != hash {
+				return nil, apperror.Newf(apperror.BadUserInput, "provided sha256Hash %v does not match query", providedHash)
+			}
+
+			persistedQuery := models.PersistedQuery{
+				Hash:          hash,
+				Query:         query,
+				OperationName: operationName,
+				Safelisted:    true,
+				CreatedAt:     time.Now(),
+				CreatedBy:     common.GetUserName(p),
+			}
+			persistedQueries = append(persistedQueries, persistedQuery)
+
+			// Queries already stored by Automatic Persisted Queries get safelisted, and don't expire anymore
+			writeModels = append(writeModels, mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"hash": hash}).
+				SetUpdate(bson.M{
+					"$set":   bson.M{"safelisted": true},
+					"$unset": bson.M{"expiresAt": ""},
+					"$setOnInsert": bson.M{
+						"query":         query,
+						"operationName": operationName,
+						"createdAt":     persistedQuery.CreatedAt,
+						"createdBy":     persistedQuery.CreatedBy,
+					},
+				}).
+				SetUpsert(true))
+		}
+
+		if len(writeModels) == 0 {
+			return persistedQueries, nil
+		}
+
+		err := models.BulkWrite(p.Context, models.PersistedQueryCollection, writeModels)
+		return persistedQueries, err
+
+	}),
+}
@@ -0,0 +1,130 @@
+package mutation
+
+import (
+	"errors"
+	"go-graphql-mongo-server/apperror"
+	"go-graphql-mongo-server/common"
+	"go-graphql-mongo-server/gqlhandler/schema"
+	"go-graphql-mongo-server/models"
+	"go-graphql-mongo-server/telemetry"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var roleBindingArgs = graphql.FieldConfigArgument{
+	"userName": &graphql.ArgumentConfig{
+		Type: graphql.NewNonNull(graphql.String),
+	},
+	"role": &graphql.ArgumentConfig{
+		Type: graphql.NewNonNull(graphql.String),
+	},
+}
+
+var GrantRoleMutation = &graphql.Field{
+	Name:        "GrantRole",
+	Type:        schema.RoleBindingSchema,
+	Description: "Grant a role to a user",
+	Args:        roleBindingArgs,
+	Resolve: common.RequirePermission(models.PermissionRolesAdmin, func(p graphql.ResolveParams) (i interface{}, e error) {
+
+		_, err := common.Sanitize(p.Args)
+		if err != nil {
+			return nil, err
+		}
+
+		defer telemetry.LogGraphQlCall(p, e)
+
+		userName, _ := p.Args["userName"].(string)
+		roleName, _ := p.Args["role"].(string)
+
+		role, err := models.RoleLoader(p.Context).Get(p.Context, roleName)
+		if err != nil {
+			return nil, err
+		}
+		if role == nil {
+			return nil, apperror.Newf(apperror.NotFound, "role %v does not exist", roleName)
+		}
+
+		before, err := models.RoleBindingLoader(p.Context).Get(p.Context, userName)
+		if err != nil {
+			return nil, err
+		}
+
+		err = models.Upsert(
+			p.Context,
+			models.RoleBindingCollection,
+			bson.M{"userName": userName},
+			bson.M{
+				"$addToSet": bson.M{"roles": roleName},
+				"$set":      bson.M{"updatedAt": time.Now(), "updatedBy": common.GetUserName(p)},
+			},
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		return findRoleBinding(p, userName, before)
+
+	}),
+}
+
+var RevokeRoleMutation = &graphql.Field{
+	Name:        "RevokeRole",
+	Type:        schema.RoleBindingSchema,
+	Description: "Revoke a role from a user",
+	Args:        roleBindingArgs,
+	Resolve: common.RequirePermission(models.PermissionRolesAdmin, func(p graphql.ResolveParams) (i interface{}, e error) {
+
+		_, err := common.Sanitize(p.Args)
+		if err != nil {
+			return nil, err
+		}
+
+		defer telemetry.LogGraphQlCall(p, e)
+
+		userName, _ := p.Args["userName"].(string)
+		roleName, _ := p.Args["role"].(string)
+
+		before, err := models.RoleBindingLoader(p.Context).Get(p.Context, userName)
+		if err != nil {
+			return nil, err
+		}
+
+		err = models.Update(
+			p.Context,
+			models.RoleBindingCollection,
+			bson.M{"userName": userName, "roles": roleName},
+			bson.M{
+				"$pull": bson.M{"roles": roleName},
+				"$set":  bson.M{"updatedAt": time.Now(), "updatedBy": common.GetUserName(p)},
+			},
+		)
+		if errors.Is(err, models.ErrNoDocumentFound) {
+			return nil, apperror.Newf(apperror.NotFound, "user %v does not have the role %v", userName, roleName)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return findRoleBinding(p, userName, before)
+
+	}),
+}
+
+// Reads the role binding of a user after it was modified, the memoized binding of the request is dropped
+// The changes from the binding read before the modification are recorded in the audit log.
+func findRoleBinding(p graphql.ResolveParams, userName string, before *models.RoleBinding) (*models.RoleBinding, error) {
+
+	loader := models.RoleBindingLoader(p.Context)
+	loader.Clear(userName)
+
+	after, err := loader.Get(p.Context, userName)
+	if err != nil {
+		return nil, err
+	}
+
+	common.SetAuditChanges(p, before, after)
+	return after, nil
+}
@@ -0,0 +1,369 @@
+package mutation
+
+import (
+	"errors"
+	"go-graphql-mongo-server/apperror"
+	"go-graphql-mongo-server/auth"
+	"go-graphql-mongo-server/common"
+	"go-graphql-mongo-server/config"
+	"go-graphql-mongo-server/gqlhandler/schema"
+	"go-graphql-mongo-server/logger"
+	"go-graphql-mongo-server/models"
+	"go-graphql-mongo-server/telemetry"
+	"strings"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// A scoped caller must not be able to create a key with more permissions than its own
+var errScopedServiceKeyAdmin = apperror.New(apperror.Forbidden, "service keys can not be managed with a scoped token or key")
+
+var serviceKeyNameArg = graphql.FieldConfigArgument{
+	"name": &graphql.ArgumentConfig{
+		Type:        graphql.NewNonNull(graphql.String),
+		Description: "Name of the service",
+	},
+}
+
+var CreateServiceKeyMutation = &graphql.Field{
+	Name:        "CreateServiceKey",
+	Type:        schema.ServiceKeySchema,
+	Description: "Create an API key for a backend service, the key is only returned once",
+	Args: common.MergeArgs(serviceKeyNameArg, graphql.FieldConfigArgument{
+		"owner": &graphql.ArgumentConfig{
+			Type:        graphql.NewNonNull(graphql.String),
+			Description: "Team or person responsible for the service",
+		},
+		"scopes": &graphql.ArgumentConfig{
+			Type:        graphql.NewList(graphql.NewNonNull(graphql.String)),
+			Description: "Permissions (Eg. users:read) the key is restricted to. Without scopes the key gets the configured default scopes.",
+		},
+		"expiresAt": &graphql.ArgumentConfig{
+			Type:        graphql.DateTime,
+			Description: "Without expiry the key is valid until it is disabled or deleted",
+		},
+	}),
+	Resolve: common.RequirePermission(models.PermissionServiceKeysAdmin, func(p graphql.ResolveParams) (i interface{}, e error) {
+
+		if common.GetScopes(p) != nil {
+			return nil, errScopedServiceKeyAdmin
+		}
+
+		_, err := common.Sanitize(p.Args)
+		if err != nil {
+			return nil, err
+		}
+
+		defer telemetry.LogGraphQlCall(p, e)
+
+		now := time.Now()
+		key := models.ServiceKey{
+			Enabled:   true,
+			CreatedAt: now,
+			CreatedBy: common.GetUserName(p),
+			UpdatedAt: now,
+			UpdatedBy: common.GetUserName(p),
+		}
+		key.Name, _ = p.Args["name"].(string)
+		key.Owner, _ = p.Args["owner"].(string)
+
+		key.Scopes, err = getServiceKeyScopes(p)
+		if err != nil {
+			return nil, err
+		}
+
+		key.ExpiresAt, err = getServiceKeyExpiry(p)
+		if err != nil {
+			return nil, err
+		}
+
+		err = auth.GenerateServiceKey(&key)
+		if err != nil {
+			return nil, err
+		}
+
+		err = models.Insert(p.Context, models.ServiceKeyCollection, key)
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, apperror.Newf(apperror.Conflict, "a service key named %v already exists", key.Name)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// The key is only returned here, only its hash is stored
+		return key, nil
+
+	}),
+}
+
+var UpdateServiceKeyMutation = &graphql.Field{
+	Name:        "UpdateServiceKey",
+	Type:        schema.ServiceKeySchema,
+	Description: "Update the owner, scopes or expiry of a service key, or enable or disable it",
+	Args: common.MergeArgs(serviceKeyNameArg, graphql.FieldConfigArgument{
+		"owner": &graphql.ArgumentConfig{
+			Type: graphql.String,
+		},
+		"scopes": &graphql.ArgumentConfig{
+			Type: graphql.NewList(graphql.NewNonNull(graphql.String)),
+		},
+		"expiresAt": &graphql.ArgumentConfig{
+			Type: graphql.DateTime,
+		},
+		"clearExpiry": &graphql.ArgumentConfig{
+			Type:        graphql.Boolean,
+			Description: "Remove the expiry, the key is then valid until it is disabled or deleted",
+		},
+		"enabled": &graphql.ArgumentConfig{
+			Type:        graphql.Boolean,
+			Description: "A disabled key is rejected until it is enabled again",
+		},
+	}),
+	Resolve: common.RequirePermission(models.PermissionServiceKeysAdmin, func(p graphql.ResolveParams) (i interface{}, e error) {
+
+		if common.GetScopes(p) != nil {
+			return nil, errScopedServiceKeyAdmin
+		}
+
+		_, err := common.Sanitize(p.Args)
+		if err != nil {
+			return nil, err
+		}
+
+		defer telemetry.LogGraphQlCall(p, e)
+
+		name, _ := p.Args["name"].(string)
+		before, err := findServiceKey(p, name)
+		if err != nil {
+			return nil, err
+		}
+
+		update := bson.M{"updatedAt": time.Now(), "updatedBy": common.GetUserName(p)}
+		changes := bson.M{}
+
+		if owner, ok := p.Args["owner"].(string); ok {
+			update["owner"] = owner
+		}
+		if enabled, ok := p.Args["enabled"].(bool); ok {
+			update["enabled"] = enabled
+		}
+		if p.Args["scopes"] != nil {
+			update["scopes"], err = getServiceKeyScopes(p)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		clearExpiry, _ := p.Args["clearExpiry"].(bool)
+		if clearExpiry && p.Args["expiresAt"] != nil {
+			return nil, apperror.New(apperror.BadUserInput, "expiresAt and clearExpiry can not be used together")
+		}
+		if clearExpiry {
+			changes["$unset"] = bson.M{"expiresAt": ""}
+		}
+		if p.Args["expiresAt"] != nil {
+			update["expiresAt"], err = getServiceKeyExpiry(p)
+			if err != nil {
+				return nil, err
+			}
+		}
+		changes["$set"] = update
+
+		err = models.Update(p.Context, models.ServiceKeyCollection, bson.M{"name": name}, changes)
+		if errors.Is(err, models.ErrNoDocumentFound) {
+			return nil, apperror.Newf(apperror.NotFound, "service key %v does not exist", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		after, err := findServiceKey(p, name)
+		if err != nil {
+			return nil, err
+		}
+
+		common.SetAuditChanges(p, before, after)
+		return after, nil
+
+	}),
+}
+
+var RotateServiceKeyMutation = &graphql.Field{
+	Name:        "RotateServiceKey",
+	Type:        schema.ServiceKeySchema,
+	Description: "Issue a new key for a service, the old key stays valid during the grace period",
+	Args: common.MergeArgs(serviceKeyNameArg, graphql.FieldConfigArgument{
+		"gracePeriod": &graphql.ArgumentConfig{
+			Type:        graphql.String,
+			Description: "Duration (Eg. 24h) for which the old key stays valid. Defaults to and can not exceed the configured grace period.",
+		},
+		"failIfInGracePeriod": failIfInGracePeriodArg,
+	}),
+	Resolve: common.RequirePermission(models.PermissionServiceKeysAdmin, func(p graphql.ResolveParams) (i interface{}, e error) {
+
+		if common.GetScopes(p) != nil {
+			return nil, errScopedServiceKeyAdmin
+		}
+
+		_, err := common.Sanitize(p.Args)
+		if err != nil {
+			return nil, err
+		}
+
+		defer telemetry.LogGraphQlCall(p, e)
+
+		gracePeriod, err := getRotationGracePeriod(p)
+		if err != nil {
+			return nil, err
+		}
+
+		name, _ := p.Args["name"].(string)
+		current, err := findServiceKey(p, name)
+		if err != nil {
+			return nil, err
+		}
+
+		// Same policy as the tokens, a key still in its grace period is revoked by a new rotation
+		err = checkRotationPolicy(p, "service key "+name, current.PreviousKeyExpiresAt)
+		if err != nil {
+			return nil, err
+		}
+
+		key := *current
+		err = auth.GenerateServiceKey(&key)
+		if err != nil {
+			return nil, err
+		}
+
+		rotatedAt := time.Now()
+		previousKeyExpiresAt := rotatedAt.Add(gracePeriod)
+		key.PreviousKeyHash = &current.KeyHash
+		key.PreviousKeyExpiresAt = &previousKeyExpiresAt
+		key.RotatedAt = &rotatedAt
+		key.UpdatedAt = rotatedAt
+		key.UpdatedBy = common.GetUserName(p)
+
+		// Matching the current hash makes sure that a concurrent rotation is not overwritten
+		err = models.Update(p.Context, models.ServiceKeyCollection, bson.M{"name": name, "keyHash": current.KeyHash}, bson.M{"$set": bson.M{
+			"keyHash":              key.KeyHash,
+			"previousKeyHash":      key.PreviousKeyHash,
+			"previousKeyExpiresAt": key.PreviousKeyExpiresAt,
+			"rotatedAt":            key.RotatedAt,
+			"updatedAt":            key.UpdatedAt,
+			"updatedBy":            key.UpdatedBy,
+		}})
+		if errors.Is(err, models.ErrNoDocumentFound) {
+			return nil, apperror.Newf(apperror.Conflict, "service key %v was modified concurrently, please retry", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		common.SetAuditChanges(p, current, key)
+
+		// The new key is only returned here, only its hash is stored
+		return key, nil
+
+	}),
+}
+
+var DeleteServiceKeyMutation = &graphql.Field{
+	Name:        "DeleteServiceKey",
+	Type:        graphql.Boolean,
+	Description: "Delete a service key, the service can not call the server with it anymore",
+	Args:        serviceKeyNameArg,
+	Resolve: common.RequirePermission(models.PermissionServiceKeysAdmin, func(p graphql.ResolveParams) (i interface{}, e error) {
+
+		if common.GetScopes(p) != nil {
+			return false, errScopedServiceKeyAdmin
+		}
+
+		_, err := common.Sanitize(p.Args)
+		if err != nil {
+			return false, err
+		}
+
+		defer telemetry.LogGraphQlCall(p, e)
+
+		name, _ := p.Args["name"].(string)
+		deleted, err := models.DeleteMany(p.Context, models.ServiceKeyCollection, bson.M{"name": name})
+		if err != nil {
+			return false, err
+		}
+		if deleted == 0 {
+			return false, apperror.Newf(apperror.NotFound, "service key %v does not exist", name)
+		}
+		return true, nil
+
+	}),
+}
+
+func findServiceKey(p graphql.ResolveParams, name string) (*models.ServiceKey, error) {
+
+	var key models.ServiceKey
+	err := models.FindOne(p.Context, models.ServiceKeyCollection, bson.M{"name": name}, nil, &key)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, apperror.Newf(apperror.NotFound, "service key %v does not exist", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// Reads the scopes of a service key, the configured default scopes are used without the scopes argument
+// Keys are always given scopes, as an unscoped key calls the server as the internal user with every permission.
+func getServiceKeyScopes(p graphql.ResolveParams) ([]string, error) {
+
+	if p.Args["scopes"] != nil {
+		requestedScopes, _ := p.Args["scopes"].([]interface{})
+		if len(requestedScopes) == 0 {
+			return nil, apperror.New(apperror.BadUserInput, "scopes must not be empty, a service key can not be unrestricted")
+		}
+		return getTokenScopes(p, toStrings(requestedScopes))
+	}
+
+	var scopes []string
+	for _, scope := range strings.Split(config.Store.ServiceKeyDefaultScopes, ",") {
+		scope = strings.TrimSpace(scope)
+		if scope == "" {
+			continue
+		}
+		if !models.IsPermission(scope) {
+			logger.Log.Errorf("Invalid default service key scope %v", scope)
+			continue
+		}
+		scopes = append(scopes, scope)
+	}
+
+	if len(scopes) == 0 {
+		return nil, apperror.New(apperror.BadUserInput, "scopes are required, no default service key scopes are configured")
+	}
+	return scopes, nil
+}
+
+// Reads the optional expiresAt argument of a service key, which must be in the future
+func getServiceKeyExpiry(p graphql.ResolveParams) (*time.Time, error) {
+
+	expiresAt, ok := p.Args["expiresAt"].(time.Time)
+	if !ok {
+		return nil, nil
+	}
+	if !expiresAt.After(time.Now()) {
+		return nil, apperror.New(apperror.BadUserInput, "expiresAt must be in the future")
+	}
+	return &expiresAt, nil
+}
+
+func toStrings(values []interface{}) []string {
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		if s, ok := value.(string); ok {
+			result = append(result, s)
+		}
+	}
+	return result
+}
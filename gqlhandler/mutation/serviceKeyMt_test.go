@@ -0,0 +1,120 @@
+package mutation
+
+import (
+	"errors"
+	"go-graphql-mongo-server/apperror"
+	"go-graphql-mongo-server/config"
+	"go-graphql-mongo-server/logger"
+	"go-graphql-mongo-server/models"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/graphql-go/graphql"
+)
+
+func TestGetServiceKeyScopes(t *testing.T) {
+
+	logger.Initialize()
+
+	tests := []struct {
+		name          string
+		args          map[string]interface{}
+		defaultScopes string
+		want          []string
+		wantErr       bool
+	}{
+		{
+			name:          "default scopes",
+			args:          map[string]interface{}{},
+			defaultScopes: "users:read, audit:read",
+			want:          []string{models.PermissionUsersRead, models.PermissionAuditRead},
+		},
+		{
+			name:          "unknown default scopes are skipped",
+			args:          map[string]interface{}{},
+			defaultScopes: "users:read,users:*",
+			want:          []string{models.PermissionUsersRead},
+		},
+		{
+			name:          "no default scopes",
+			args:          map[string]interface{}{},
+			defaultScopes: "",
+			wantErr:       true,
+		},
+		{
+			name:          "requested scopes without duplicates",
+			args:          map[string]interface{}{"scopes": []interface{}{"users:write", "users:write", "roles:admin"}},
+			defaultScopes: "users:read",
+			want:          []string{models.PermissionUsersWrite, models.PermissionRolesAdmin},
+		},
+		{
+			name:          "empty scopes",
+			args:          map[string]interface{}{"scopes": []interface{}{}},
+			defaultScopes: "users:read",
+			wantErr:       true,
+		},
+		{
+			name:          "unknown scope",
+			args:          map[string]interface{}{"scopes": []interface{}{"users:*"}},
+			defaultScopes: "users:read",
+			wantErr:       true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config.Store.ServiceKeyDefaultScopes = test.defaultScopes
+
+			got, err := getServiceKeyScopes(graphql.ResolveParams{Args: test.args})
+			if test.wantErr {
+				var appError *apperror.Error
+				if !errors.As(err, &appError) || appError.Code != apperror.BadUserInput {
+					t.Errorf("got error %v, want a %v error", err, apperror.BadUserInput)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCheckRotationPolicy(t *testing.T) {
+
+	inGracePeriod := time.Now().Add(time.Hour)
+	expired := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name              string
+		args              map[string]interface{}
+		previousExpiresAt *time.Time
+		wantConflict      bool
+	}{
+		{name: "never rotated", args: map[string]interface{}{}, previousExpiresAt: nil},
+		{name: "previous secret in its grace period is revoked", args: map[string]interface{}{}, previousExpiresAt: &inGracePeriod},
+		{name: "previous secret expired", args: map[string]interface{}{"failIfInGracePeriod": true}, previousExpiresAt: &expired},
+		{name: "fail if in grace period", args: map[string]interface{}{"failIfInGracePeriod": true}, previousExpiresAt: &inGracePeriod, wantConflict: true},
+		{name: "explicitly revoke", args: map[string]interface{}{"failIfInGracePeriod": false}, previousExpiresAt: &inGracePeriod},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := checkRotationPolicy(graphql.ResolveParams{Args: test.args}, "token test", test.previousExpiresAt)
+			if !test.wantConflict {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			var appError *apperror.Error
+			if !errors.As(err, &appError) || appError.Code != apperror.Conflict {
+				t.Errorf("got error %v, want a %v error", err, apperror.Conflict)
+			}
+		})
+	}
+}
@@ -1,18 +1,21 @@
 package mutation
 
 import (
-	"fmt"
+	"errors"
+	"go-graphql-mongo-server/apperror"
 	"go-graphql-mongo-server/auth"
 	"go-graphql-mongo-server/common"
+	"go-graphql-mongo-server/config"
 	"go-graphql-mongo-server/gqlhandler/schema"
 	"go-graphql-mongo-server/logger"
 	"go-graphql-mongo-server/models"
 	"go-graphql-mongo-server/telemetry"
-	"strings"
+	"time"
 
 	"github.com/graphql-go/graphql"
 	"github.com/mitchellh/mapstructure"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 var CreateTokenMutation = &graphql.Field{
@@ -28,13 +31,22 @@ var CreateTokenMutation = &graphql.Field{
 		},
 		"userName": &graphql.ArgumentConfig{
 			Type:        graphql.String,
-			Description: "This username will only be used when Admin runs this mutation. Otherwise this will be ignored.",
+			Description: "This username will only be used when a user with the tokens:admin permission runs this mutation. Otherwise this will be ignored.",
+		},
+		"scopes": &graphql.ArgumentConfig{
+			Type:        graphql.NewList(graphql.NewNonNull(graphql.String)),
+			Description: "Permissions (Eg. users:read) the token is restricted to. Without scopes the token has all the permissions of the user.",
 		},
 	},
 	Resolve: func(p graphql.ResolveParams) (i interface{}, e error) {
 
-		if common.IsValidUser(p) {
-			return nil, common.ErrUnauthorized
+		if !common.IsValidUser(p) {
+			return nil, common.ErrUnauthenticated
+		}
+
+		// A scoped token must not be able to create an unrestricted token
+		if common.GetScopes(p) != nil {
+			return nil, common.ErrScopedToken
 		}
 
 		_, err := common.Sanitize(p.Args)
@@ -44,7 +56,10 @@ var CreateTokenMutation = &graphql.Field{
 
 		defer telemetry.LogGraphQlCall(p, e)
 
-		userName := common.GetTokenUserName(p)
+		userName, err := common.GetTokenUserName(p)
+		if err != nil {
+			return nil, err
+		}
 
 		var token models.Token
 		//Decode input to token
@@ -54,14 +69,24 @@ var CreateTokenMutation = &graphql.Field{
 		}
 		token.UserName = userName
 
+		err = validateTokenExpiry(token.ExpiresAt)
+		if err != nil {
+			return nil, err
+		}
+
+		token.Scopes, err = getTokenScopes(p, token.Scopes)
+		if err != nil {
+			return nil, err
+		}
+
 		err = auth.GenerateToken(&token)
 		if err != nil {
 			return nil, err
 		}
 
 		err = models.Insert(p.Context, models.TokenCollection, token)
-		if err != nil && strings.Contains(err.Error(), "duplicate key error") {
-			return nil, fmt.Errorf("a token with same name already exists")
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, apperror.New(apperror.Conflict, "a token with same name already exists")
 		}
 
 		return token, err
@@ -79,13 +104,17 @@ var RevokeTokenMutation = &graphql.Field{
 		},
 		"userName": &graphql.ArgumentConfig{
 			Type:        graphql.String,
-			Description: "This username will only be used when Admin runs this mutation. Otherwise this will be ignored.",
+			Description: "This username will only be used when a user with the tokens:admin permission runs this mutation. Otherwise this will be ignored.",
 		},
 	},
 	Resolve: func(p graphql.ResolveParams) (i interface{}, e error) {
 
-		if common.IsValidUser(p) {
-			return false, common.ErrUnauthorized
+		if !common.IsValidUser(p) {
+			return false, common.ErrUnauthenticated
+		}
+
+		if common.GetScopes(p) != nil {
+			return false, common.ErrScopedToken
 		}
 
 		_, err := common.Sanitize(p.Args)
@@ -95,17 +124,241 @@ var RevokeTokenMutation = &graphql.Field{
 
 		defer telemetry.LogGraphQlCall(p, e)
 
-		userName := common.GetUserName(p)
+		userName, err := common.GetTokenUserName(p)
+		if err != nil {
+			return false, err
+		}
 
-		err = models.Delete(
+		tokenName, _ := p.Args["tokenName"].(string)
+		deleted, err := models.DeleteMany(
 			p.Context,
 			models.TokenCollection,
-			bson.M{"tokenName": p.Args["tokenName"].(string), "userName": userName},
+			bson.M{"tokenName": tokenName, "userName": userName},
 		)
 		if err != nil {
 			return false, err
 		}
+		if deleted == 0 {
+			return false, apperror.Newf(apperror.NotFound, "token %v of user %v does not exist", tokenName, userName)
+		}
 		return true, nil
 
 	},
 }
+
+var RotateTokenMutation = &graphql.Field{
+	Name:        "RotateToken",
+	Type:        schema.TokenSchema,
+	Description: "Issue a new secret for a personal access token, the old secret stays valid during the grace period",
+	Args: graphql.FieldConfigArgument{
+		"tokenName": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(graphql.String),
+		},
+		"gracePeriod": &graphql.ArgumentConfig{
+			Type:        graphql.String,
+			Description: "Duration (Eg. 24h) for which the old secret stays valid. Defaults to and can not exceed the configured grace period.",
+		},
+		"expiresAt": &graphql.ArgumentConfig{
+			Type:        graphql.DateTime,
+			Description: "Expiry of the new secret. By default the token keeps its expiry.",
+		},
+		"failIfInGracePeriod": failIfInGracePeriodArg,
+		"userName": &graphql.ArgumentConfig{
+			Type:        graphql.String,
+			Description: "This username will only be used when a user with the tokens:admin permission runs this mutation. Otherwise this will be ignored.",
+		},
+	},
+	Resolve: func(p graphql.ResolveParams) (i interface{}, e error) {
+
+		if !common.IsValidUser(p) {
+			return nil, common.ErrUnauthenticated
+		}
+
+		if common.GetScopes(p) != nil {
+			return nil, common.ErrScopedToken
+		}
+
+		_, err := common.Sanitize(p.Args)
+		if err != nil {
+			return nil, err
+		}
+
+		defer telemetry.LogGraphQlCall(p, e)
+
+		userName, err := common.GetTokenUserName(p)
+		if err != nil {
+			return nil, err
+		}
+
+		gracePeriod, err := getRotationGracePeriod(p)
+		if err != nil {
+			return nil, err
+		}
+
+		tokenName, _ := p.Args["tokenName"].(string)
+		// Expired tokens wait for the TTL index to remove them, they can not be rotated
+		filter := bson.M{"tokenName": tokenName, "userName": userName, "expiresAt": bson.M{"$gt": time.Now()}}
+
+		var current models.Token
+		err = models.FindOne(p.Context, models.TokenCollection, filter, nil, &current)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, apperror.Newf(apperror.NotFound, "token %v does not exist", tokenName)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		err = checkRotationPolicy(p, "token "+tokenName, current.PreviousTokenExpiresAt)
+		if err != nil {
+			return nil, err
+		}
+
+		// Rotating must not extend the lifetime of the token
+		token := models.Token{
+			TokenName: current.TokenName,
+			UserName:  current.UserName,
+			Scopes:    current.Scopes,
+			ExpiresAt: current.ExpiresAt,
+		}
+		if expiresAt, ok := p.Args["expiresAt"].(time.Time); ok {
+			token.ExpiresAt = expiresAt
+		}
+
+		err = validateTokenExpiry(token.ExpiresAt)
+		if err != nil {
+			return nil, err
+		}
+
+		err = auth.GenerateToken(&token)
+		if err != nil {
+			return nil, err
+		}
+
+		rotatedAt := token.CreatedAt
+		token.PreviousTokenHash = &current.TokenHash
+		previousTokenExpiresAt := rotatedAt.Add(gracePeriod)
+		token.PreviousTokenExpiresAt = &previousTokenExpiresAt
+		token.RotatedAt = &rotatedAt
+
+		// Matching the current hash makes sure that a concurrent rotation is not overwritten
+		filter["tokenHash"] = current.TokenHash
+		err = models.Update(p.Context, models.TokenCollection, filter, bson.M{"$set": bson.M{
+			"tokenHash":              token.TokenHash,
+			"createdAt":              token.CreatedAt,
+			"expiresAt":              token.ExpiresAt,
+			"previousTokenHash":      token.PreviousTokenHash,
+			"previousTokenExpiresAt": token.PreviousTokenExpiresAt,
+			"rotatedAt":              token.RotatedAt,
+		}})
+		if errors.Is(err, models.ErrNoDocumentFound) {
+			return nil, apperror.Newf(apperror.Conflict, "token %v was modified concurrently, please retry", tokenName)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		after := current
+		after.TokenHash = token.TokenHash
+		after.CreatedAt = token.CreatedAt
+		after.ExpiresAt = token.ExpiresAt
+		after.PreviousTokenHash = token.PreviousTokenHash
+		after.PreviousTokenExpiresAt = token.PreviousTokenExpiresAt
+		after.RotatedAt = token.RotatedAt
+		common.SetAuditChanges(p, current, after)
+
+		// The new secret is only returned here, only its hash is stored
+		return token, nil
+
+	},
+}
+
+var failIfInGracePeriodArg = &graphql.ArgumentConfig{
+	Type:        graphql.Boolean,
+	Description: "Fail instead of revoking the previous secret when it is still in its grace period. By default it is revoked right away, so that a leaked secret can be replaced at any time.",
+}
+
+// checkRotationPolicy applies the rotation policy shared by tokens and service keys
+// Only one previous secret is kept: rotating again while the previous secret is in its grace period revokes it right away,
+// unless failIfInGracePeriod is set, in which case the rotation fails with a conflict.
+func checkRotationPolicy(p graphql.ResolveParams, name string, previousExpiresAt *time.Time) error {
+
+	failIfInGracePeriod, _ := p.Args["failIfInGracePeriod"].(bool)
+	if !failIfInGracePeriod || previousExpiresAt == nil || !previousExpiresAt.After(time.Now()) {
+		return nil
+	}
+
+	return apperror.Newf(
+		apperror.Conflict,
+		"%v was already rotated, its previous secret is valid until %v",
+		name,
+		previousExpiresAt.UTC().Format(time.RFC3339),
+	)
+}
+
+// Reads the gracePeriod argument of RotateToken and RotateServiceKey, bounded by config.Store.TokenRotationGracePeriod
+func getRotationGracePeriod(p graphql.ResolveParams) (time.Duration, error) {
+
+	maxGracePeriod, err := time.ParseDuration(config.Store.TokenRotationGracePeriod)
+	if err != nil {
+		logger.Log.Errorf("Invalid token rotation grace period %v : %v", config.Store.TokenRotationGracePeriod, err)
+		maxGracePeriod = 0
+	}
+
+	gracePeriodArg, _ := p.Args["gracePeriod"].(string)
+	if gracePeriodArg == "" {
+		return maxGracePeriod, nil
+	}
+
+	gracePeriod, err := time.ParseDuration(gracePeriodArg)
+	if err != nil || gracePeriod < 0 {
+		return 0, apperror.Newf(apperror.BadUserInput, "invalid gracePeriod %v", gracePeriodArg)
+	}
+	if gracePeriod > maxGracePeriod {
+		return 0, apperror.Newf(apperror.BadUserInput, "gracePeriod must be at most %v", maxGracePeriod)
+	}
+	return gracePeriod, nil
+}
+
+// Validates the scopes requested for a new token, nil when the token is unrestricted
+func getTokenScopes(p graphql.ResolveParams, requested []string) ([]string, error) {
+
+	if p.Args["scopes"] == nil {
+		return nil, nil
+	}
+	if len(requested) == 0 {
+		return nil, apperror.New(apperror.BadUserInput, "scopes must not be empty, omit them to create an unrestricted token")
+	}
+
+	scopes := make([]string, 0, len(requested))
+	seen := map[string]bool{}
+	for _, scope := range requested {
+		if !models.IsPermission(scope) {
+			return nil, apperror.Newf(apperror.BadUserInput, "unknown scope %v", scope)
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes, nil
+}
+
+// The expiry of a new token must be in the future and within the maximum token lifetime
+func validateTokenExpiry(expiresAt time.Time) error {
+
+	now := time.Now()
+	if !expiresAt.After(now) {
+		return apperror.New(apperror.BadUserInput, "expiresAt must be in the future")
+	}
+
+	maxLifetime, err := time.ParseDuration(config.Store.MaxTokenLifetime)
+	if err != nil {
+		logger.Log.Errorf("Invalid max token lifetime %v : %v", config.Store.MaxTokenLifetime, err)
+		return nil
+	}
+
+	if maxLifetime > 0 && expiresAt.After(now.Add(maxLifetime)) {
+		return apperror.Newf(apperror.BadUserInput, "expiresAt must be at most %v in the future", maxLifetime)
+	}
+	return nil
+}
@@ -1,14 +1,19 @@
 package mutation
 
 import (
+	"errors"
+	"go-graphql-mongo-server/apperror"
 	"go-graphql-mongo-server/common"
 	"go-graphql-mongo-server/gqlhandler/schema"
 	"go-graphql-mongo-server/logger"
 	"go-graphql-mongo-server/models"
 	"go-graphql-mongo-server/telemetry"
+	"time"
 
 	"github.com/graphql-go/graphql"
 	"github.com/mitchellh/mapstructure"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 var UserMutation = &graphql.Field{
@@ -20,11 +25,7 @@ var UserMutation = &graphql.Field{
 			Type: graphql.NewNonNull(graphql.NewList(schema.UserInputSchema)),
 		},
 	},
-	Resolve: func(p graphql.ResolveParams) (i interface{}, e error) {
-
-		if !common.IsInternalUser(p) {
-			return nil, common.ErrUnauthorized
-		}
+	Resolve: common.RequirePermission(models.PermissionUsersWrite, func(p graphql.ResolveParams) (i interface{}, e error) {
 
 		_, err := common.Sanitize(p.Args)
 		if err != nil {
@@ -51,5 +52,298 @@ var UserMutation = &graphql.Field{
 		err = models.InsertMany(p.Context, models.UserCollection, userInputInterface)
 		return userInput, err
 
+	}),
+}
+
+var UpdateUserMutation = &graphql.Field{
+	Name:        "UpdateUser",
+	Type:        graphql.NewNonNull(schema.UserMutationResultSchema),
+	Description: "Replace all the fields of an existing user, the user is identified by the id in the input. Fields missing from the input are removed.",
+	Args: graphql.FieldConfigArgument{
+		"input": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(schema.UserInputSchema),
+		},
+	},
+	Resolve: common.RequirePermission(models.PermissionUsersWrite, func(p graphql.ResolveParams) (i interface{}, e error) {
+
+		_, err := common.Sanitize(p.Args)
+		if err != nil {
+			return nil, err
+		}
+
+		defer telemetry.LogGraphQlCall(p, e)
+
+		var user models.User
+
+		//Decode input to User
+		err = mapstructure.Decode(p.Args["input"], &user)
+		if err != nil {
+			logger.Log.Error(err)
+			return nil, apperror.Newf(apperror.BadUserInput, "invalid user input: %v", err)
+		}
+
+		return updateUser(p, user.ID, func(filter bson.M) error {
+			return models.Replace(p.Context, models.UserCollection, filter, user)
+		})
+
+	}),
+}
+
+var PatchUserMutation = &graphql.Field{
+	Name:        "PatchUser",
+	Type:        graphql.NewNonNull(schema.UserMutationResultSchema),
+	Description: "Update only the provided fields of an existing user",
+	Args: graphql.FieldConfigArgument{
+		"id": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(graphql.Int),
+		},
+		"input": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(schema.UserPatchInputSchema),
+		},
+	},
+	Resolve: common.RequirePermission(models.PermissionUsersWrite, func(p graphql.ResolveParams) (i interface{}, e error) {
+
+		_, err := common.Sanitize(p.Args)
+		if err != nil {
+			return nil, err
+		}
+
+		defer telemetry.LogGraphQlCall(p, e)
+
+		input, _ := p.Args["input"].(map[string]interface{})
+
+		// Set nested fields with dotted paths, so that the unspecified fields of address are kept
+		fields := bson.M{}
+		flattenPatch("", input, fields)
+		if len(fields) == 0 {
+			return nil, apperror.New(apperror.BadUserInput, "nothing to update")
+		}
+
+		return updateUser(p, p.Args["id"].(int), func(filter bson.M) error {
+			return models.Update(p.Context, models.UserCollection, filter, bson.M{"$set": fields})
+		})
+
+	}),
+}
+
+var DeleteUsersMutation = &graphql.Field{
+	Name:        "DeleteUsers",
+	Type:        graphql.NewNonNull(schema.DeleteUsersResultSchema),
+	Description: "Soft delete users either by a list of ids or by a filter. Deleted users can be restored until they are purged.",
+	Args: graphql.FieldConfigArgument{
+		"ids": &graphql.ArgumentConfig{
+			Type: graphql.NewList(graphql.NewNonNull(graphql.Int)),
+		},
+		"filter": &graphql.ArgumentConfig{
+			Type: schema.UserFilterSchema,
+		},
+	},
+	Resolve: common.RequirePermission(models.PermissionUsersWrite, func(p graphql.ResolveParams) (i interface{}, e error) {
+
+		_, err := common.Sanitize(p.Args)
+		if err != nil {
+			return nil, err
+		}
+
+		defer telemetry.LogGraphQlCall(p, e)
+
+		ids, idsPresent := p.Args["ids"].([]interface{})
+		filterInput, filterPresent := p.Args["filter"].(map[string]interface{})
+		if idsPresent == filterPresent {
+			return nil, apperror.New(apperror.BadUserInput, "exactly one of ids or filter must be provided")
+		}
+
+		var filter bson.M
+		if idsPresent {
+			filter = bson.M{"id": bson.M{"$in": ids}}
+		} else {
+			filter, err = common.BuildMongoFilter(filterInput, schema.UserFilterFields)
+			if err != nil {
+				return nil, err
+			}
+			if len(filter) == 0 {
+				return nil, apperror.New(apperror.BadUserInput, "filter can not be empty")
+			}
+		}
+
+		result := models.DeleteUsersResult{
+			Deleted:     []models.User{},
+			NotFoundIDs: []int{},
+		}
+
+		// Read the users first, so that the deleted documents can be returned
+		err = models.FindAll(
+			p.Context,
+			models.UserCollection,
+			models.ExcludeDeletedUsers(filter),
+			common.BuildProjection(p, []string{"deleted"}, "id"),
+			&result.Deleted,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		foundIDs := make(map[int]bool, len(result.Deleted))
+		deleteIDs := make([]int, 0, len(result.Deleted))
+		for _, user := range result.Deleted {
+			foundIDs[user.ID] = true
+			deleteIDs = append(deleteIDs, user.ID)
+		}
+
+		for _, id := range ids {
+			if !foundIDs[id.(int)] {
+				result.NotFoundIDs = append(result.NotFoundIDs, id.(int))
+			}
+		}
+
+		if len(deleteIDs) == 0 {
+			return result, nil
+		}
+
+		deletedAt := time.Now()
+		deletedBy := common.GetUserName(p)
+
+		// Only delete what was read, so that the response matches what got deleted
+		_, err = models.UpdateMany(
+			p.Context,
+			models.UserCollection,
+			models.ExcludeDeletedUsers(bson.M{"id": bson.M{"$in": deleteIDs}}),
+			bson.M{"$set": bson.M{"deletedAt": deletedAt, "deletedBy": deletedBy}},
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range result.Deleted {
+			result.Deleted[i].DeletedAt = &deletedAt
+			result.Deleted[i].DeletedBy = deletedBy
+		}
+
+		return result, nil
+
+	}),
+}
+
+var RestoreUsersMutation = &graphql.Field{
+	Name:        "RestoreUsers",
+	Type:        graphql.NewNonNull(schema.RestoreUsersResultSchema),
+	Description: "Restore soft deleted users",
+	Args: graphql.FieldConfigArgument{
+		"ids": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.Int))),
+		},
 	},
+	Resolve: common.RequirePermission(models.PermissionUsersWrite, func(p graphql.ResolveParams) (i interface{}, e error) {
+
+		_, err := common.Sanitize(p.Args)
+		if err != nil {
+			return nil, err
+		}
+
+		defer telemetry.LogGraphQlCall(p, e)
+
+		ids, _ := p.Args["ids"].([]interface{})
+		filter := bson.M{"id": bson.M{"$in": ids}, "deletedAt": bson.M{"$ne": nil}}
+
+		result := models.RestoreUsersResult{
+			Restored:    []models.User{},
+			NotFoundIDs: []int{},
+		}
+
+		err = models.FindAll(
+			p.Context,
+			models.UserCollection,
+			filter,
+			common.BuildProjection(p, []string{"restored"}, "id"),
+			&result.Restored,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		foundIDs := make(map[int]bool, len(result.Restored))
+		for i := range result.Restored {
+			foundIDs[result.Restored[i].ID] = true
+			result.Restored[i].DeletedAt = nil
+			result.Restored[i].DeletedBy = ""
+		}
+
+		for _, id := range ids {
+			if !foundIDs[id.(int)] {
+				result.NotFoundIDs = append(result.NotFoundIDs, id.(int))
+			}
+		}
+
+		if len(result.Restored) == 0 {
+			return result, nil
+		}
+
+		_, err = models.UpdateMany(
+			p.Context,
+			models.UserCollection,
+			filter,
+			bson.M{"$unset": bson.M{"deletedAt": "", "deletedBy": ""}},
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		return result, nil
+
+	}),
+}
+
+// Updates a user which is not soft deleted, write applies the update to the documents matching the filter
+func updateUser(p graphql.ResolveParams, id int, write func(filter bson.M) error) (models.UserMutationResult, error) {
+
+	result := models.UserMutationResult{ID: id}
+
+	// Soft deleted users have to be restored before they can be updated
+	filter := models.ExcludeDeletedUsers(bson.M{"id": id})
+
+	// The user is read before and after the update, so that its changes can be recorded in the audit log
+	var before models.User
+	err := models.FindOne(p.Context, models.UserCollection, filter, nil, &before)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		result.NotFound = true
+		return result, nil
+	}
+	if err != nil {
+		return result, err
+	}
+
+	err = write(filter)
+	if errors.Is(err, models.ErrNoDocumentFound) {
+		result.NotFound = true
+		return result, nil
+	}
+
+	// Updating a user with the same values is not an error
+	if err != nil && !errors.Is(err, models.ErrNoDocumentModified) {
+		return result, err
+	}
+
+	var user models.User
+	err = models.FindOne(p.Context, models.UserCollection, filter, nil, &user)
+	if err != nil {
+		return result, err
+	}
+
+	common.SetAuditChanges(p, before, user)
+	result.User = &user
+	return result, nil
+}
+
+// Converts nested input into dotted paths, Eg. {address: {city: "X"}} to {"address.city": "X"}
+func flattenPatch(prefix string, input map[string]interface{}, fields bson.M) {
+	for key, value := range input {
+		if value == nil {
+			continue
+		}
+		if nested, ok := value.(map[string]interface{}); ok {
+			flattenPatch(prefix+key+".", nested, fields)
+			continue
+		}
+		fields[prefix+key] = value
+	}
 }
@@ -0,0 +1,217 @@
+package gqlhandler
+
+import (
+	"context"
+	"errors"
+	"go-graphql-mongo-server/common"
+	"go-graphql-mongo-server/config"
+	"go-graphql-mongo-server/logger"
+	"go-graphql-mongo-server/models"
+	"sync"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Maximum number of persisted queries kept in memory
+const persistedQueryCacheSize = 10000
+
+// Used when PERSISTED_QUERY_TTL is invalid, the documents stored by Automatic Persisted Queries must expire
+const defaultPersistedQueryTTL = 720 * time.Hour
+
+// Persisted queries never change for a hash, so they are cached until they expire
+var persistedQueryCache = struct {
+	lock    sync.RWMutex
+	queries map[string]models.PersistedQuery
+}{
+	queries: make(map[string]models.PersistedQuery),
+}
+
+type persistedQueryError struct {
+	message string
+	code    string
+}
+
+func (e persistedQueryError) Error() string {
+	return e.message
+}
+
+func (e persistedQueryError) formatted() gqlerrors.FormattedError {
+	return gqlerrors.FormattedError{
+		Message:    e.message,
+		Extensions: map[string]interface{}{"code": e.code},
+	}
+}
+
+var (
+	// Messages & codes expected by Apollo clients
+	errPersistedQueryNotFound     = persistedQueryError{"PersistedQueryNotFound", "PERSISTED_QUERY_NOT_FOUND"}
+	errPersistedQueryNotSupported = persistedQueryError{"PersistedQueryNotSupported", "PERSISTED_QUERY_NOT_SUPPORTED"}
+	errPersistedQueryHashMismatch = persistedQueryError{"provided sha does not match query", "BAD_USER_INPUT"}
+	errPersistedQueryTooLong      = persistedQueryError{"query length exceeded", "BAD_USER_INPUT"}
+	errOperationNotSafelisted     = persistedQueryError{"operation is not in the persisted query safelist", "OPERATION_NOT_SAFELISTED"}
+)
+
+// resolvePersistedQuery fills in the query of a request from the persisted queries (Automatic Persisted Queries)
+// and, in safelist only mode, rejects operations which are not registered.
+// Users allowed to register operations are not bound by the safelist, so that they can try new operations.
+// A query sent with its hash is returned to be stored with storePersistedQuery, once the request passed the other checks.
+func resolvePersistedQuery(ctx context.Context, request *models.GQLRequestBody) (*models.PersistedQuery, *persistedQueryError) {
+
+	persistedQueryExtension := request.Extensions.PersistedQuery
+	safelistOnly := config.Store.PersistedQueriesOnly && !canWritePersistedQueries(ctx)
+
+	if persistedQueryExtension == nil {
+		if !safelistOnly {
+			return nil, nil
+		}
+
+		// Full documents are allowed as long as they are in the safelist
+		persistedQuery, found := findPersistedQuery(ctx, models.PersistedQueryHash(request.Query), true)
+		if !found || !persistedQuery.Safelisted {
+			return nil, &errOperationNotSafelisted
+		}
+		return nil, nil
+	}
+
+	if persistedQueryExtension.Version != 1 || persistedQueryExtension.Sha256Hash == "" {
+		return nil, &errPersistedQueryNotSupported
+	}
+	hash := persistedQueryExtension.Sha256Hash
+
+	// Only the hash is sent, look up the query
+	if request.Query == "" {
+		persistedQuery, found := findPersistedQuery(ctx, hash, safelistOnly)
+		if !found {
+			return nil, &errPersistedQueryNotFound
+		}
+		if safelistOnly && !persistedQuery.Safelisted {
+			return nil, &errOperationNotSafelisted
+		}
+		request.Query = persistedQuery.Query
+		return nil, nil
+	}
+
+	// Both query & hash are sent, register the query for the next requests
+	if len(request.Query) > models.MaxPersistedQueryLength {
+		return nil, &errPersistedQueryTooLong
+	}
+	if models.PersistedQueryHash(request.Query) != hash {
+		return nil, &errPersistedQueryHashMismatch
+	}
+
+	if safelistOnly {
+		persistedQuery, found := findPersistedQuery(ctx, hash, true)
+		if !found || !persistedQuery.Safelisted {
+			return nil, &errOperationNotSafelisted
+		}
+		return nil, nil
+	}
+
+	persistedQuery := models.PersistedQuery{
+		Hash:          hash,
+		Query:         request.Query,
+		OperationName: request.OperationName,
+		CreatedAt:     time.Now(),
+	}
+
+	ttl, err := time.ParseDuration(config.Store.PersistedQueryTTL)
+	if err != nil || ttl <= 0 {
+		logger.Log.Errorf("Invalid persisted query TTL %v : %v", config.Store.PersistedQueryTTL, err)
+		ttl = defaultPersistedQueryTTL
+	}
+	expiresAt := persistedQuery.CreatedAt.Add(ttl)
+	persistedQuery.ExpiresAt = &expiresAt
+
+	return &persistedQuery, nil
+}
+
+// Reports whether the user of the request has the persistedQueries:write permission, within the scopes of its token or key
+func canWritePersistedQueries(ctx context.Context) bool {
+	allowed, err := common.HasPermission(graphql.ResolveParams{Context: ctx}, models.PermissionPersistedQueriesWrite)
+	if err != nil {
+		logger.Log.Errorf("Error in checking the permissions of %v : %v", ctx.Value(models.UserContextKey), err)
+	}
+	return allowed
+}
+
+// findPersistedQuery looks up a persisted query in memory and then in the database
+// needsSafelisted skips cached queries which are not safelisted, as they may have been registered since.
+func findPersistedQuery(ctx context.Context, hash string, needsSafelisted bool) (models.PersistedQuery, bool) {
+
+	now := time.Now()
+
+	persistedQueryCache.lock.RLock()
+	persistedQuery, found := persistedQueryCache.queries[hash]
+	persistedQueryCache.lock.RUnlock()
+	if found && isPersistedQueryExpired(persistedQuery, now) {
+		evictPersistedQuery(hash)
+		found = false
+	}
+	if found && (persistedQuery.Safelisted || !needsSafelisted) {
+		return persistedQuery, true
+	}
+
+	// The TTL index removes the expired documents only periodically
+	filter := bson.M{
+		"hash": hash,
+		"$or":  []bson.M{{"expiresAt": nil}, {"expiresAt": bson.M{"$gt": now}}},
+	}
+	persistedQuery = models.PersistedQuery{}
+	err := models.FindOne(ctx, models.PersistedQueryCollection, filter, nil, &persistedQuery)
+	if err != nil {
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			logger.Log.Errorf("Error in finding persisted query %v : %v", hash, err)
+		}
+		return persistedQuery, false
+	}
+
+	cachePersistedQuery(persistedQuery)
+	return persistedQuery, true
+}
+
+func storePersistedQuery(ctx context.Context, persistedQuery models.PersistedQuery) {
+
+	err := models.Upsert(
+		ctx,
+		models.PersistedQueryCollection,
+		bson.M{"hash": persistedQuery.Hash},
+		bson.M{"$setOnInsert": persistedQuery},
+	)
+
+	// The query may have already been stored by another request
+	if err != nil && !errors.Is(err, models.ErrNoDocumentModified) {
+		logger.Log.Errorf("Error in storing persisted query %v : %v", persistedQuery.Hash, err)
+		return
+	}
+
+	cachePersistedQuery(persistedQuery)
+}
+
+func cachePersistedQuery(persistedQuery models.PersistedQuery) {
+	persistedQueryCache.lock.Lock()
+	defer persistedQueryCache.lock.Unlock()
+
+	// Only cache new entries while there is space, the rest is served from the database
+	if _, exists := persistedQueryCache.queries[persistedQuery.Hash]; !exists && len(persistedQueryCache.queries) >= persistedQueryCacheSize {
+		return
+	}
+	persistedQueryCache.queries[persistedQuery.Hash] = persistedQuery
+}
+
+func evictPersistedQuery(hash string) {
+	persistedQueryCache.lock.Lock()
+	defer persistedQueryCache.lock.Unlock()
+
+	// Another request may have cached it again meanwhile
+	if persistedQuery, found := persistedQueryCache.queries[hash]; found && isPersistedQueryExpired(persistedQuery, time.Now()) {
+		delete(persistedQueryCache.queries, hash)
+	}
+}
+
+func isPersistedQueryExpired(persistedQuery models.PersistedQuery, now time.Time) bool {
+	return persistedQuery.ExpiresAt != nil && !persistedQuery.ExpiresAt.After(now)
+}
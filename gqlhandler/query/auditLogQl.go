@@ -0,0 +1,53 @@
+package query
+
+import (
+	"go-graphql-mongo-server/common"
+	"go-graphql-mongo-server/gqlhandler/schema"
+	"go-graphql-mongo-server/models"
+	"go-graphql-mongo-server/telemetry"
+
+	"github.com/graphql-go/graphql"
+)
+
+var AuditLogQuery = &graphql.Field{
+	Name:        "AuditLog",
+	Type:        graphql.NewNonNull(schema.AuditLogConnectionSchema),
+	Description: "Get the audit log page by page, sorted by timestamp",
+	Args: common.MergeArgs(
+		schema.ConnectionArgs,
+		graphql.FieldConfigArgument{
+			"sortOrder": &graphql.ArgumentConfig{
+				Type:         schema.SortOrderEnum,
+				DefaultValue: "DESC",
+				Description:  "The latest entries come first by default",
+			},
+			"filter": &graphql.ArgumentConfig{
+				Type: schema.AuditLogFilterSchema,
+			},
+		},
+	),
+	Resolve: common.RequirePermission(models.PermissionAuditRead, func(p graphql.ResolveParams) (i interface{}, e error) {
+
+		_, err := common.Sanitize(p.Args)
+		if err != nil {
+			return nil, err
+		}
+
+		defer telemetry.LogGraphQlCall(p, e)
+
+		filterInput, _ := p.Args["filter"].(map[string]interface{})
+		filter, err := common.BuildMongoFilter(filterInput, schema.AuditLogFilterFields)
+		if err != nil {
+			return nil, err
+		}
+
+		return models.FindPage[models.AuditLog](
+			p.Context,
+			models.AuditLogCollection,
+			filter,
+			common.BuildProjection(p, []string{"edges", "node"}, "timestamp"),
+			common.GetPageOptions(p, "timestamp"),
+		)
+
+	}),
+}
@@ -0,0 +1,47 @@
+package query
+
+import (
+	"go-graphql-mongo-server/common"
+	"go-graphql-mongo-server/gqlhandler/schema"
+	"go-graphql-mongo-server/models"
+	"go-graphql-mongo-server/telemetry"
+
+	"github.com/graphql-go/graphql"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var ServiceKeysQuery = &graphql.Field{
+	Name:        "ServiceKeys",
+	Type:        graphql.NewList(schema.ServiceKeySchema),
+	Description: "Get the API keys of the backend services, without the keys themselves",
+	Args: graphql.FieldConfigArgument{
+		"owner": &graphql.ArgumentConfig{
+			Type: graphql.String,
+		},
+	},
+	Resolve: common.RequirePermission(models.PermissionServiceKeysAdmin, func(p graphql.ResolveParams) (i interface{}, e error) {
+
+		_, err := common.Sanitize(p.Args)
+		if err != nil {
+			return nil, err
+		}
+
+		defer telemetry.LogGraphQlCall(p, e)
+
+		filter := bson.M{}
+		if owner, ok := p.Args["owner"].(string); ok {
+			filter["owner"] = owner
+		}
+
+		var keys []models.ServiceKey
+		err = models.FindAll(
+			p.Context,
+			models.ServiceKeyCollection,
+			filter,
+			common.BuildProjection(p, nil),
+			&keys,
+		)
+		return keys, err
+
+	}),
+}
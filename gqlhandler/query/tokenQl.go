@@ -1,10 +1,12 @@
 package query
 
 import (
+	"go-graphql-mongo-server/apperror"
 	"go-graphql-mongo-server/common"
 	"go-graphql-mongo-server/gqlhandler/schema"
 	"go-graphql-mongo-server/models"
 	"go-graphql-mongo-server/telemetry"
+	"time"
 
 	"github.com/graphql-go/graphql"
 	"go.mongodb.org/mongo-driver/bson"
@@ -17,13 +19,17 @@ var TokenQuery = &graphql.Field{
 	Args: graphql.FieldConfigArgument{
 		"userName": &graphql.ArgumentConfig{
 			Type:        graphql.String,
-			Description: "This username will only be used when Admin runs this query. Otherwise this will be ignored.",
+			Description: "This username will only be used when a user with the tokens:admin permission runs this query. Otherwise this will be ignored.",
 		},
 	},
 	Resolve: func(p graphql.ResolveParams) (i interface{}, e error) {
 
 		if !common.IsValidUser(p) {
-			return nil, common.ErrUnauthorized
+			return nil, common.ErrUnauthenticated
+		}
+
+		if common.GetScopes(p) != nil {
+			return nil, common.ErrScopedToken
 		}
 
 		_, err := common.Sanitize(p.Args)
@@ -33,12 +39,67 @@ var TokenQuery = &graphql.Field{
 
 		defer telemetry.LogGraphQlCall(p, e)
 
-		userName := common.GetTokenUserName(p)
+		userName, err := common.GetTokenUserName(p)
+		if err != nil {
+			return nil, err
+		}
 
 		//Get Tokens from db
 		var tokens []models.Token
-		err = models.FindAll(p.Context, models.TokenCollection, bson.M{"userName": userName}, nil, &tokens)
-		return tokens, err
+		err = models.FindAll(
+			p.Context,
+			models.TokenCollection,
+			bson.M{"userName": userName},
+			common.BuildProjection(p, nil, "expiresAt"),
+			&tokens,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		models.FlagExpiringTokens(tokens)
+		return tokens, nil
 
 	},
 }
+
+var UnusedTokensQuery = &graphql.Field{
+	Name:        "UnusedTokens",
+	Type:        graphql.NewList(schema.TokenSchema),
+	Description: "Get the tokens of all users which were not used for some days",
+	Args: graphql.FieldConfigArgument{
+		"days": &graphql.ArgumentConfig{
+			Type:        graphql.NewNonNull(graphql.Int),
+			Description: "Tokens which were never used are listed when they were created before this number of days",
+		},
+	},
+	Resolve: common.RequirePermission(models.PermissionTokensAdmin, func(p graphql.ResolveParams) (i interface{}, e error) {
+
+		_, err := common.Sanitize(p.Args)
+		if err != nil {
+			return nil, err
+		}
+
+		defer telemetry.LogGraphQlCall(p, e)
+
+		days, _ := p.Args["days"].(int)
+		if days <= 0 {
+			return nil, apperror.New(apperror.BadUserInput, "days must be positive")
+		}
+		unusedSince := time.Now().AddDate(0, 0, -days)
+
+		var tokens []models.Token
+		err = models.FindAll(
+			p.Context,
+			models.TokenCollection,
+			bson.M{"$or": []bson.M{
+				{"lastUsedAt": bson.M{"$lt": unusedSince}},
+				{"lastUsedAt": nil, "createdAt": bson.M{"$lt": unusedSince}},
+			}},
+			common.BuildProjection(p, nil),
+			&tokens,
+		)
+		return tokens, err
+
+	}),
+}
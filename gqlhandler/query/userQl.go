@@ -12,31 +12,26 @@ import (
 
 var UsersQuery = &graphql.Field{
 	Name:        "Users",
-	Type:        graphql.NewList(schema.UserSchema),
-	Description: "Get all users",
-	Args: graphql.FieldConfigArgument{
-		// Add optional filters for users
-		"id": &graphql.ArgumentConfig{
-			Type: graphql.Int,
+	Type:        graphql.NewNonNull(schema.UsersConnectionSchema),
+	Description: "Get users page by page",
+	Args: common.MergeArgs(
+		schema.ConnectionArgs,
+		graphql.FieldConfigArgument{
+			"sortBy": &graphql.ArgumentConfig{
+				Type:         schema.UserSortByEnum,
+				DefaultValue: "id",
+			},
+			"filter": &graphql.ArgumentConfig{
+				Type: schema.UserFilterSchema,
+			},
+			"includeDeleted": &graphql.ArgumentConfig{
+				Type:         graphql.Boolean,
+				DefaultValue: false,
+				Description:  "Include soft deleted users. Requires the users:write permission.",
+			},
 		},
-		"name": &graphql.ArgumentConfig{
-			Type: graphql.String,
-		},
-		"dob": &graphql.ArgumentConfig{
-			Type: graphql.DateTime,
-		},
-		"isVerified": &graphql.ArgumentConfig{
-			Type: graphql.Boolean,
-		},
-		"subscription": &graphql.ArgumentConfig{
-			Type: schema.SubscriptionTypeEnum,
-		},
-	},
-	Resolve: func(p graphql.ResolveParams) (i interface{}, e error) {
-
-		if !common.IsValidUser(p) {
-			return nil, common.ErrUnauthorized
-		}
+	),
+	Resolve: common.RequirePermission(models.PermissionUsersRead, func(p graphql.ResolveParams) (i interface{}, e error) {
 
 		_, err := common.Sanitize(p.Args)
 		if err != nil {
@@ -48,10 +43,36 @@ var UsersQuery = &graphql.Field{
 		userName := common.GetUserName(p)
 		logger.Log.Info("Query: Users called by " + userName)
 
-		//Get Users from db
-		var users []models.User
-		err = models.FindAll(p.Context, models.UserCollection, p.Args, nil, &users)
-		return users, err
+		includeDeleted, _ := p.Args["includeDeleted"].(bool)
+		if includeDeleted {
+			canWrite, err := common.HasPermission(p, models.PermissionUsersWrite)
+			if err != nil {
+				return nil, err
+			}
+			if !canWrite {
+				return nil, common.ErrUnauthorized
+			}
+		}
+
+		filterInput, _ := p.Args["filter"].(map[string]interface{})
+		filter, err := common.BuildMongoFilter(filterInput, schema.UserFilterFields)
+		if err != nil {
+			return nil, err
+		}
+		if !includeDeleted {
+			filter = models.ExcludeDeletedUsers(filter)
+		}
+
+		sortBy, _ := p.Args["sortBy"].(string)
+
+		//Get Users page from db
+		return models.FindPage[models.User](
+			p.Context,
+			models.UserCollection,
+			filter,
+			common.BuildProjection(p, []string{"edges", "node"}, sortBy),
+			common.GetPageOptions(p, sortBy),
+		)
 
-	},
+	}),
 }
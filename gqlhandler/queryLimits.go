@@ -0,0 +1,313 @@
+package gqlhandler
+
+import (
+	"fmt"
+	"go-graphql-mongo-server/config"
+	"go-graphql-mongo-server/models"
+	"strconv"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+)
+
+// Cost of resolving a field, keyed by "<Type>.<field>". Fields not listed here cost 1.
+var fieldCosts = map[string]int{
+	"Query.Users":                       10,
+	"Query.Tokens":                      5,
+	"Query.UnusedTokens":                20,
+	"Query.ServiceKeys":                 10,
+	"Query.AuditLog":                    10,
+	"AuditLogConnection.totalCount":     20,
+	"UsersConnection.totalCount":        20,
+	"Mutation.AddUsers":                 10,
+	"Mutation.UpdateUser":               10,
+	"Mutation.PatchUser":                10,
+	"Mutation.DeleteUsers":              20,
+	"Mutation.RestoreUsers":             20,
+	"Subscription.userChanged":          50,
+	"Mutation.CreateToken":              10,
+	"Mutation.RevokeToken":              10,
+	"Mutation.RotateToken":              10,
+	"Mutation.GrantRole":                10,
+	"Mutation.RevokeRole":               10,
+	"Mutation.CreateServiceKey":         10,
+	"Mutation.UpdateServiceKey":         10,
+	"Mutation.RotateServiceKey":         10,
+	"Mutation.DeleteServiceKey":         10,
+	"Mutation.RegisterPersistedQueries": 20,
+}
+
+// Arguments limiting the number of items returned by a list field
+var listSizeArgs = []string{"first", "last", "limit"}
+
+type queryLimitError struct {
+	limit  string
+	max    int
+	actual int
+
+	// Set when the operation fits the maximum complexity, but not the complexity left by the previous operations of the batch
+	isBatchBudget bool
+	remaining     int
+}
+
+func (e queryLimitError) Error() string {
+	if e.isBatchBudget {
+		return fmt.Sprintf("query %v of %d exceeds the %v of %d left in the batch, the maximum allowed %v is %d", e.limit, e.actual, e.limit, e.remaining, e.limit, e.max)
+	}
+	return fmt.Sprintf("query %v of %d exceeds the maximum allowed %v of %d", e.limit, e.actual, e.limit, e.max)
+}
+
+func (e queryLimitError) Extensions() map[string]interface{} {
+	extensions := map[string]interface{}{
+		"code":   "QUERY_LIMIT_EXCEEDED",
+		"limit":  e.limit,
+		"max":    e.max,
+		"actual": e.actual,
+	}
+	if e.isBatchBudget {
+		extensions["remaining"] = e.remaining
+	}
+	return extensions
+}
+
+func (e queryLimitError) formatted() gqlerrors.FormattedError {
+	return gqlerrors.FormattedError{
+		Message:    e.Error(),
+		Extensions: e.Extensions(),
+	}
+}
+
+// Walks a document and measures it against the configured limits
+type queryAnalyzer struct {
+	schema    *graphql.Schema
+	fragments map[string]*ast.FragmentDefinition
+	variables map[string]interface{}
+	aliases   int
+
+	// Fragments being measured, to stop on fragment cycles which are only rejected later by the validation
+	visiting map[string]bool
+
+	// Fragments already measured, so that a fragment spread many times is walked only once
+	measured map[string]fragmentMeasure
+}
+
+// Measure of a fragment, its depth is relative to the depth of the spread
+type fragmentMeasure struct {
+	cost    int
+	depth   int
+	aliases int
+}
+
+// checkQueryLimits rejects operations which are too deep, use too many aliases or are too expensive
+// maxComplexity is the complexity allowed for the caller and remaining what is left of it in the batch.
+// It returns the cost of the operation, so that the cost of a batch can be accounted for.
+// Documents which can not be parsed are left to graphql.Do to report.
+func checkQueryLimits(request models.GQLRequestBody, maxComplexity int, remaining int) (int, *queryLimitError) {
+
+	document, err := parser.Parse(parser.ParseParams{Source: request.Query})
+	if err != nil {
+		return 0, nil
+	}
+
+	analyzer := &queryAnalyzer{
+		schema:    &SchemaQl,
+		fragments: map[string]*ast.FragmentDefinition{},
+		variables: request.Variables,
+		visiting:  map[string]bool{},
+		measured:  map[string]fragmentMeasure{},
+	}
+
+	var operations []*ast.OperationDefinition
+	for _, definition := range document.Definitions {
+		switch definition := definition.(type) {
+		case *ast.FragmentDefinition:
+			analyzer.fragments[definition.Name.Value] = definition
+		case *ast.OperationDefinition:
+			if request.OperationName == "" || (definition.Name != nil && definition.Name.Value == request.OperationName) {
+				operations = append(operations, definition)
+			}
+		}
+	}
+
+	var cost, depth int
+	for _, operation := range operations {
+		rootType := analyzer.rootType(operation.Operation)
+		operationCost, operationDepth := analyzer.measure(rootType, operation.SelectionSet, 0)
+		cost += operationCost
+		if operationDepth > depth {
+			depth = operationDepth
+		}
+	}
+
+	limits := config.Store.QueryLimits
+	if limits.MaxQueryDepth > 0 && depth > limits.MaxQueryDepth {
+		return cost, &queryLimitError{limit: "depth", max: limits.MaxQueryDepth, actual: depth}
+	}
+	if limits.MaxQueryAliases > 0 && analyzer.aliases > limits.MaxQueryAliases {
+		return cost, &queryLimitError{limit: "alias count", max: limits.MaxQueryAliases, actual: analyzer.aliases}
+	}
+	if maxComplexity > 0 && cost > maxComplexity {
+		return cost, &queryLimitError{limit: "complexity", max: maxComplexity, actual: cost}
+	}
+	if maxComplexity > 0 && cost > remaining {
+		return cost, &queryLimitError{limit: "complexity", max: maxComplexity, actual: cost, isBatchBudget: true, remaining: remaining}
+	}
+
+	return cost, nil
+}
+
+func (a *queryAnalyzer) rootType(operation string) *graphql.Object {
+	switch operation {
+	case ast.OperationTypeMutation:
+		return a.schema.MutationType()
+	case ast.OperationTypeSubscription:
+		return a.schema.SubscriptionType()
+	default:
+		return a.schema.QueryType()
+	}
+}
+
+// measure returns the cost and the depth of a selection set on the parent type
+func (a *queryAnalyzer) measure(parentType *graphql.Object, selectionSet *ast.SelectionSet, depth int) (int, int) {
+
+	if selectionSet == nil {
+		return 0, depth
+	}
+
+	var cost int
+	maxDepth := depth
+
+	for _, selection := range selectionSet.Selections {
+
+		var selectionCost, selectionDepth int
+
+		switch selection := selection.(type) {
+
+		case *ast.Field:
+			// Introspection is not counted, so that GraphiQl keeps working
+			if strings.HasPrefix(selection.Name.Value, "__") {
+				continue
+			}
+			if selection.Alias != nil {
+				a.aliases++
+			}
+			selectionCost, selectionDepth = a.measureField(parentType, selection, depth+1)
+
+		case *ast.InlineFragment:
+			fragmentType := parentType
+			if selection.TypeCondition != nil {
+				fragmentType, _ = a.schema.Type(selection.TypeCondition.Name.Value).(*graphql.Object)
+			}
+			selectionCost, selectionDepth = a.measure(fragmentType, selection.SelectionSet, depth)
+
+		case *ast.FragmentSpread:
+			measure, found := a.measureFragment(selection.Name.Value)
+			if !found {
+				continue
+			}
+			a.aliases += measure.aliases
+			selectionCost, selectionDepth = measure.cost, depth+measure.depth
+		}
+
+		cost += selectionCost
+		if selectionDepth > maxDepth {
+			maxDepth = selectionDepth
+		}
+	}
+
+	return cost, maxDepth
+}
+
+// measureFragment walks a fragment the first time it is spread and reuses its measure afterwards,
+// otherwise fragments spreading each other several times would be walked an exponential number of times
+func (a *queryAnalyzer) measureFragment(name string) (fragmentMeasure, bool) {
+
+	if measure, found := a.measured[name]; found {
+		return measure, true
+	}
+
+	fragment, found := a.fragments[name]
+	if !found || a.visiting[name] {
+		return fragmentMeasure{}, false
+	}
+
+	a.visiting[name] = true
+	aliases := a.aliases
+	fragmentType, _ := a.schema.Type(fragment.TypeCondition.Name.Value).(*graphql.Object)
+	cost, depth := a.measure(fragmentType, fragment.SelectionSet, 0)
+	delete(a.visiting, name)
+
+	// The aliases are counted again by the caller, like for every other spread of the fragment
+	measure := fragmentMeasure{cost: cost, depth: depth, aliases: a.aliases - aliases}
+	a.aliases = aliases
+	a.measured[name] = measure
+	return measure, true
+}
+
+func (a *queryAnalyzer) measureField(parentType *graphql.Object, field *ast.Field, depth int) (int, int) {
+
+	cost := 1
+	multiplier := 1
+	var fieldType *graphql.Object
+
+	if parentType != nil {
+		if weight, found := fieldCosts[parentType.Name()+"."+field.Name.Value]; found {
+			cost = weight
+		}
+
+		if fieldDefinition, found := parentType.Fields()[field.Name.Value]; found {
+			fieldType, _ = graphql.GetNamed(fieldDefinition.Type).(*graphql.Object)
+			multiplier = a.listMultiplier(fieldDefinition, field)
+		}
+	}
+
+	childrenCost, childrenDepth := a.measure(fieldType, field.SelectionSet, depth)
+	return cost + multiplier*childrenCost, childrenDepth
+}
+
+// The children of a list field are resolved once per item, so their cost is multiplied by the page size
+func (a *queryAnalyzer) listMultiplier(fieldDefinition *graphql.FieldDefinition, field *ast.Field) int {
+
+	var hasListSizeArg bool
+	for _, argDefinition := range fieldDefinition.Args {
+		for _, name := range listSizeArgs {
+			if argDefinition.Name() == name {
+				hasListSizeArg = true
+			}
+		}
+	}
+	if !hasListSizeArg {
+		return 1
+	}
+
+	for _, argument := range field.Arguments {
+		for _, name := range listSizeArgs {
+			if argument.Name.Value == name {
+				if size := a.intValue(argument.Value); size > 0 {
+					return size
+				}
+			}
+		}
+	}
+
+	return models.DefaultPageSize
+}
+
+func (a *queryAnalyzer) intValue(value ast.Value) int {
+	switch value := value.(type) {
+	case *ast.IntValue:
+		intValue, _ := strconv.Atoi(value.Value)
+		return intValue
+	case *ast.Variable:
+		switch variable := a.variables[value.Name.Value].(type) {
+		case float64:
+			return int(variable)
+		case int:
+			return variable
+		}
+	}
+	return 0
+}
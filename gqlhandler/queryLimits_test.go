@@ -0,0 +1,169 @@
+package gqlhandler
+
+import (
+	"fmt"
+	"go-graphql-mongo-server/config"
+	"go-graphql-mongo-server/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckQueryLimits(t *testing.T) {
+
+	config.Store.QueryLimits = config.QueryLimits{MaxQueryDepth: 5, MaxQueryAliases: 2, MaxQueryComplexity: 1000}
+	defer func() { config.Store.QueryLimits = config.QueryLimits{} }()
+
+	tests := []struct {
+		name          string
+		request       models.GQLRequestBody
+		maxComplexity int
+		remaining     int
+		wantCost      int
+		wantLimit     string
+		wantIsBatch   bool
+	}{
+		{
+			name:          "list field multiplied by its page size",
+			request:       models.GQLRequestBody{Query: `{ Users(first: 2) { edges { node { name } } } }`},
+			maxComplexity: 1000,
+			remaining:     1000,
+			wantCost:      10 + 2*(1+1+1),
+		},
+		{
+			name:          "page size from a variable",
+			request:       models.GQLRequestBody{Query: `query($n: Int) { Users(first: $n) { edges { cursor } } }`, Variables: map[string]interface{}{"n": float64(3)}},
+			maxComplexity: 1000,
+			remaining:     1000,
+			wantCost:      10 + 3*(1+1),
+		},
+		{
+			name:          "introspection is free",
+			request:       models.GQLRequestBody{Query: `{ __schema { types { name } } }`},
+			maxComplexity: 1000,
+			remaining:     1000,
+			wantCost:      0,
+		},
+		{
+			name:          "too deep",
+			request:       models.GQLRequestBody{Query: `{ a { b { c { d { e { f } } } } } }`},
+			maxComplexity: 1000,
+			remaining:     1000,
+			wantLimit:     "depth",
+		},
+		{
+			name:          "too many aliases",
+			request:       models.GQLRequestBody{Query: `{ a: Tokens { tokenName } b: Tokens { tokenName } c: Tokens { tokenName } }`},
+			maxComplexity: 1000,
+			remaining:     1000,
+			wantLimit:     "alias count",
+		},
+		{
+			name:          "too complex",
+			request:       models.GQLRequestBody{Query: `{ Users(first: 500) { edges { node { name } } } }`},
+			maxComplexity: 1000,
+			remaining:     1000,
+			wantLimit:     "complexity",
+		},
+		{
+			name:          "fits the maximum but not what is left in the batch",
+			request:       models.GQLRequestBody{Query: `{ Users(first: 2) { edges { node { name } } } }`},
+			maxComplexity: 1000,
+			remaining:     10,
+			wantLimit:     "complexity",
+			wantIsBatch:   true,
+		},
+		{
+			name:          "only the selected operation is measured",
+			request:       models.GQLRequestBody{Query: `query A { Tokens { tokenName } } query B { Users(first: 500) { edges { node { name } } } }`, OperationName: "A"},
+			maxComplexity: 1000,
+			remaining:     1000,
+			wantCost:      5 + 1,
+		},
+		{
+			name:          "unparsable document is left to the execution",
+			request:       models.GQLRequestBody{Query: `{ Users(`},
+			maxComplexity: 1000,
+			remaining:     1000,
+			wantCost:      0,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cost, limitErr := checkQueryLimits(test.request, test.maxComplexity, test.remaining)
+
+			if test.wantLimit == "" {
+				if limitErr != nil {
+					t.Fatalf("unexpected error: %v", limitErr)
+				}
+				if cost != test.wantCost {
+					t.Errorf("got cost %d, want %d", cost, test.wantCost)
+				}
+				return
+			}
+
+			if limitErr == nil {
+				t.Fatalf("got no error, want a %v error", test.wantLimit)
+			}
+			if limitErr.limit != test.wantLimit || limitErr.isBatchBudget != test.wantIsBatch {
+				t.Errorf("got %v, want a %v error with isBatchBudget %v", limitErr, test.wantLimit, test.wantIsBatch)
+			}
+		})
+	}
+}
+
+// The budget of the caller applies even when the global complexity limit is disabled, Eg. for guests
+func TestCheckQueryLimitsGuestBudgetWithoutGlobalLimit(t *testing.T) {
+
+	config.Store.QueryLimits = config.QueryLimits{}
+
+	tests := []struct {
+		name          string
+		maxComplexity int
+		wantErr       bool
+	}{
+		{name: "no budget", maxComplexity: 0, wantErr: false},
+		{name: "guest budget", maxComplexity: 100, wantErr: true},
+	}
+
+	request := models.GQLRequestBody{Query: `{ Users(first: 500) { edges { node { name } } } }`}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, limitErr := checkQueryLimits(request, test.maxComplexity, test.maxComplexity)
+			if (limitErr != nil) != test.wantErr {
+				t.Errorf("got error %v, want error %v", limitErr, test.wantErr)
+			}
+		})
+	}
+}
+
+// Fragments spreading the next one twice would be walked 2^n times without memoizing their measure
+func TestCheckQueryLimitsNestedFragments(t *testing.T) {
+
+	config.Store.QueryLimits = config.QueryLimits{}
+
+	const fragmentCount = 40
+
+	var query strings.Builder
+	query.WriteString("{ Tokens { ...F0 } }\n")
+	for i := 0; i < fragmentCount; i++ {
+		fmt.Fprintf(&query, "fragment F%d on Token { ...F%d ...F%d }\n", i, i+1, i+1)
+	}
+	fmt.Fprintf(&query, "fragment F%d on Token { tokenName }\n", fragmentCount)
+
+	done := make(chan int)
+	go func() {
+		cost, _ := checkQueryLimits(models.GQLRequestBody{Query: query.String()}, 0, 0)
+		done <- cost
+	}()
+
+	select {
+	case cost := <-done:
+		if want := 5 + 1<<fragmentCount; cost != want {
+			t.Errorf("got cost %d, want %d", cost, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("measuring nested fragments did not finish")
+	}
+}
@@ -0,0 +1,164 @@
+package schema
+
+import (
+	"encoding/json"
+	"go-graphql-mongo-server/models"
+
+	"github.com/graphql-go/graphql"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var AuditOutcomeEnum = graphql.NewEnum(graphql.EnumConfig{
+	Name: "AuditOutcome",
+	Values: graphql.EnumValueConfigMap{
+		"SUCCESS": &graphql.EnumValueConfig{
+			Value: models.AuditOutcomeSuccess,
+		},
+		"FAILURE": &graphql.EnumValueConfig{
+			Value: models.AuditOutcomeFailure,
+		},
+	},
+})
+
+var AuditLogSchema = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name:        "AuditLog",
+		Description: "An entry of the audit log, which records the mutations and the rejected authentications",
+		Fields: graphql.Fields{
+			"id": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.String),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					entry, _ := p.Source.(models.AuditLog)
+					return entry.ID.Hex(), nil
+				},
+			},
+			"timestamp": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.DateTime),
+			},
+			"actor": &graphql.Field{
+				Type:        graphql.String,
+				Description: "User name of the caller, empty for a rejected authentication",
+			},
+			"service": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Name of the calling service, when it authenticated with a service key or a client certificate",
+			},
+			"authMethod": &graphql.Field{
+				Type: graphql.String,
+			},
+			"clientIp": &graphql.Field{
+				Type: graphql.String,
+			},
+			"requestId": &graphql.Field{
+				Type: graphql.String,
+			},
+			"operation": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.String),
+				Description: "mutation, or authentication for a rejected authentication",
+			},
+			"name": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.String),
+				Description: "Name of the mutation (Eg. UpdateUser)",
+			},
+			"args": &graphql.Field{
+				Type:        graphql.String,
+				Description: "JSON encoded arguments, without the values of the sensitive arguments",
+				Resolve:     newAuditDocumentResolver(func(entry models.AuditLog) bson.M { return entry.Args }),
+			},
+			"before": &graphql.Field{
+				Type:        graphql.String,
+				Description: "JSON encoded values of the fields changed by an update, before the update",
+				Resolve:     newAuditDocumentResolver(func(entry models.AuditLog) bson.M { return entry.Before }),
+			},
+			"after": &graphql.Field{
+				Type:        graphql.String,
+				Description: "JSON encoded values of the fields changed by an update, after the update",
+				Resolve:     newAuditDocumentResolver(func(entry models.AuditLog) bson.M { return entry.After }),
+			},
+			"outcome": &graphql.Field{
+				Type: graphql.NewNonNull(AuditOutcomeEnum),
+			},
+			"error": &graphql.Field{
+				Type: graphql.String,
+			},
+			"count": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "Number of rejected authentications of the client IP aggregated in the entry, null for a single one",
+			},
+		},
+	},
+)
+
+// Free form documents of an audit log entry are returned as JSON
+func newAuditDocumentResolver(document func(entry models.AuditLog) bson.M) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		entry, _ := p.Source.(models.AuditLog)
+		value := document(entry)
+		if value == nil {
+			return nil, nil
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		return string(data), nil
+	}
+}
+
+var AuditLogConnectionSchema = NewConnectionSchema("AuditLog", AuditLogSchema)
+
+var AuditOutcomeFilterSchema = newScalarFilterSchema("AuditOutcomeFilter", AuditOutcomeEnum, false, false)
+
+var AuditLogFilterSchema *graphql.InputObject
+
+func init() {
+	// AuditLogFilter refers to itself for AND/OR/NOT, so the fields are given as a thunk
+	AuditLogFilterSchema = graphql.NewInputObject(
+		graphql.InputObjectConfig{
+			Name: "AuditLogFilter",
+			Fields: (graphql.InputObjectConfigFieldMapThunk)(func() graphql.InputObjectConfigFieldMap {
+				return graphql.InputObjectConfigFieldMap{
+					"timestamp": &graphql.InputObjectFieldConfig{
+						Type: DateTimeFilterSchema,
+					},
+					"actor": &graphql.InputObjectFieldConfig{
+						Type: StringFilterSchema,
+					},
+					"service": &graphql.InputObjectFieldConfig{
+						Type: StringFilterSchema,
+					},
+					"authMethod": &graphql.InputObjectFieldConfig{
+						Type: StringFilterSchema,
+					},
+					"clientIp": &graphql.InputObjectFieldConfig{
+						Type: StringFilterSchema,
+					},
+					"requestId": &graphql.InputObjectFieldConfig{
+						Type: StringFilterSchema,
+					},
+					"operation": &graphql.InputObjectFieldConfig{
+						Type: StringFilterSchema,
+					},
+					"name": &graphql.InputObjectFieldConfig{
+						Type: StringFilterSchema,
+					},
+					"outcome": &graphql.InputObjectFieldConfig{
+						Type: AuditOutcomeFilterSchema,
+					},
+					"AND": &graphql.InputObjectFieldConfig{
+						Type: graphql.NewList(graphql.NewNonNull(AuditLogFilterSchema)),
+					},
+					"OR": &graphql.InputObjectFieldConfig{
+						Type: graphql.NewList(graphql.NewNonNull(AuditLogFilterSchema)),
+					},
+					"NOT": &graphql.InputObjectFieldConfig{
+						Type: AuditLogFilterSchema,
+					},
+				}
+			}),
+		},
+	)
+}
+
+// Fields of AuditLog that can be used in an AuditLogFilter
+var AuditLogFilterFields = []string{"timestamp", "actor", "service", "authMethod", "clientIp", "requestId", "operation", "name", "outcome"}
@@ -0,0 +1,109 @@
+package schema
+
+import (
+	"context"
+
+	"github.com/graphql-go/graphql"
+)
+
+var PageInfoSchema = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name: "PageInfo",
+		Fields: graphql.Fields{
+			"hasNextPage": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.Boolean),
+			},
+			"hasPreviousPage": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.Boolean),
+			},
+			"startCursor": &graphql.Field{
+				Type: graphql.String,
+			},
+			"endCursor": &graphql.Field{
+				Type: graphql.String,
+			},
+		},
+	},
+)
+
+var SortOrderEnum = graphql.NewEnum(graphql.EnumConfig{
+	Name: "SortOrder",
+	Values: graphql.EnumValueConfigMap{
+		"ASC": &graphql.EnumValueConfig{
+			Value: "ASC",
+		},
+		"DESC": &graphql.EnumValueConfig{
+			Value: "DESC",
+		},
+	},
+})
+
+// Arguments accepted by every connection field
+var ConnectionArgs = graphql.FieldConfigArgument{
+	"first": &graphql.ArgumentConfig{
+		Type:        graphql.Int,
+		Description: "Number of items to return after the 'after' cursor",
+	},
+	"after": &graphql.ArgumentConfig{
+		Type: graphql.String,
+	},
+	"last": &graphql.ArgumentConfig{
+		Type:        graphql.Int,
+		Description: "Number of items to return before the 'before' cursor",
+	},
+	"before": &graphql.ArgumentConfig{
+		Type: graphql.String,
+	},
+	"sortOrder": &graphql.ArgumentConfig{
+		Type:         SortOrderEnum,
+		DefaultValue: "ASC",
+	},
+}
+
+type totalCounter interface {
+	TotalCount(ctx context.Context) (int64, error)
+}
+
+// NewConnectionSchema creates a Relay style connection type (<Name>Connection & <Name>Edge) for the node type
+func NewConnectionSchema(name string, nodeType *graphql.Object) *graphql.Object {
+
+	edgeSchema := graphql.NewObject(
+		graphql.ObjectConfig{
+			Name: name + "Edge",
+			Fields: graphql.Fields{
+				"node": &graphql.Field{
+					Type: graphql.NewNonNull(nodeType),
+				},
+				"cursor": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+			},
+		},
+	)
+
+	return graphql.NewObject(
+		graphql.ObjectConfig{
+			Name: name + "Connection",
+			Fields: graphql.Fields{
+				"edges": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(edgeSchema))),
+				},
+				"pageInfo": &graphql.Field{
+					Type: graphql.NewNonNull(PageInfoSchema),
+				},
+				"totalCount": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.Int),
+					Description: "Total number of items matching the filter. This runs an extra count query, so only ask for it when needed.",
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						page, ok := p.Source.(totalCounter)
+						if !ok {
+							return nil, nil
+						}
+						count, err := page.TotalCount(p.Context)
+						return int(count), err
+					},
+				},
+			},
+		},
+	)
+}
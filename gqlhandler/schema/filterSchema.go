@@ -0,0 +1,52 @@
+package schema
+
+import "github.com/graphql-go/graphql"
+
+var IntFilterSchema = newScalarFilterSchema("IntFilter", graphql.Int, true, false)
+var StringFilterSchema = newScalarFilterSchema("StringFilter", graphql.String, true, true)
+var DateTimeFilterSchema = newScalarFilterSchema("DateTimeFilter", graphql.DateTime, true, false)
+var BooleanFilterSchema = newScalarFilterSchema("BooleanFilter", graphql.Boolean, false, false)
+
+// newScalarFilterSchema creates an input object with the comparison operators supported for a scalar type
+// Range operators (_gt, _lt, ...) are only added for ordered types and text operators only for strings.
+func newScalarFilterSchema(name string, scalar graphql.Input, isOrdered bool, isText bool) *graphql.InputObject {
+
+	fields := graphql.InputObjectConfigFieldMap{
+		"_eq": &graphql.InputObjectFieldConfig{
+			Type: scalar,
+		},
+		"_ne": &graphql.InputObjectFieldConfig{
+			Type: scalar,
+		},
+		"_in": &graphql.InputObjectFieldConfig{
+			Type: graphql.NewList(graphql.NewNonNull(scalar)),
+		},
+		"_nin": &graphql.InputObjectFieldConfig{
+			Type: graphql.NewList(graphql.NewNonNull(scalar)),
+		},
+	}
+
+	if isOrdered {
+		for _, operator := range []string{"_gt", "_lt", "_gte", "_lte"} {
+			fields[operator] = &graphql.InputObjectFieldConfig{
+				Type: scalar,
+			}
+		}
+	}
+
+	if isText {
+		fields["_contains"] = &graphql.InputObjectFieldConfig{
+			Type: graphql.String,
+		}
+		fields["_startsWith"] = &graphql.InputObjectFieldConfig{
+			Type: graphql.String,
+		}
+	}
+
+	return graphql.NewInputObject(
+		graphql.InputObjectConfig{
+			Name:   name,
+			Fields: fields,
+		},
+	)
+}
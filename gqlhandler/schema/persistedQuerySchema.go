@@ -0,0 +1,44 @@
+package schema
+
+import "github.com/graphql-go/graphql"
+
+var PersistedQuerySchema = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name: "PersistedQuery",
+		Fields: graphql.Fields{
+			"sha256Hash": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+			"query": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+			"operationName": &graphql.Field{
+				Type: graphql.String,
+			},
+			"safelisted": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.Boolean),
+			},
+			"createdAt": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.DateTime),
+			},
+		},
+	},
+)
+
+var PersistedQueryInputSchema = graphql.NewInputObject(
+	graphql.InputObjectConfig{
+		Name: "PersistedQueryInput",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"query": &graphql.InputObjectFieldConfig{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+			"sha256Hash": &graphql.InputObjectFieldConfig{
+				Type:        graphql.String,
+				Description: "Optional, if given it must match the SHA256 hash of the query",
+			},
+			"operationName": &graphql.InputObjectFieldConfig{
+				Type: graphql.String,
+			},
+		},
+	},
+)
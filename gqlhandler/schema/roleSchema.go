@@ -0,0 +1,65 @@
+package schema
+
+import (
+	"go-graphql-mongo-server/models"
+
+	"github.com/graphql-go/graphql"
+)
+
+var RoleBindingSchema = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name:        "RoleBinding",
+		Description: "The roles granted to a user",
+		Fields: graphql.Fields{
+			"userName": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+			"roles": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.String))),
+			},
+			"permissions": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.String))),
+				Description: "Permissions granted by the roles of the binding, the default roles of every user are not included",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					binding, ok := p.Source.(*models.RoleBinding)
+					if !ok || binding == nil {
+						return []string{}, nil
+					}
+
+					// The roles of every binding in the result are read together
+					loadRoles := models.RoleLoader(p.Context).LoadMany(p.Context, binding.Roles)
+
+					return func() (interface{}, error) {
+						loaded, err := loadRoles()
+						if err != nil {
+							return nil, err
+						}
+						roles, _ := loaded.([]*models.Role)
+
+						permissions := []string{}
+						seen := map[string]bool{}
+						for _, role := range roles {
+							// Bindings to a role which doesn't exist anymore don't grant anything
+							if role == nil {
+								continue
+							}
+							for _, permission := range role.Permissions {
+								if !seen[permission] {
+									seen[permission] = true
+									permissions = append(permissions, permission)
+								}
+							}
+						}
+						return permissions, nil
+					}, nil
+				},
+			},
+			"updatedAt": &graphql.Field{
+				Type: graphql.DateTime,
+			},
+			"updatedBy": &graphql.Field{
+				Type: graphql.String,
+			},
+		},
+	},
+)
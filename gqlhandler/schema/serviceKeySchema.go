@@ -0,0 +1,52 @@
+package schema
+
+import "github.com/graphql-go/graphql"
+
+var ServiceKeySchema = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name:        "ServiceKey",
+		Description: "An API key identifying a backend service",
+		Fields: graphql.Fields{
+			"name": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+			"owner": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+			"key": &graphql.Field{
+				Type:        graphql.String,
+				Description: "The secret key, only returned when the key is created or rotated",
+			},
+			"scopes": &graphql.Field{
+				Type:        graphql.NewList(graphql.NewNonNull(graphql.String)),
+				Description: "Permissions the key is restricted to, null when the key has every permission",
+			},
+			"enabled": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.Boolean),
+			},
+			"expiresAt": &graphql.Field{
+				Type:        graphql.DateTime,
+				Description: "Null when the key never expires",
+			},
+			"createdAt": &graphql.Field{
+				Type: graphql.DateTime,
+			},
+			"createdBy": &graphql.Field{
+				Type: graphql.String,
+			},
+			"updatedAt": &graphql.Field{
+				Type: graphql.DateTime,
+			},
+			"updatedBy": &graphql.Field{
+				Type: graphql.String,
+			},
+			"rotatedAt": &graphql.Field{
+				Type: graphql.DateTime,
+			},
+			"previousKeyExpiresAt": &graphql.Field{
+				Type:        graphql.DateTime,
+				Description: "End of the grace period during which the key replaced by the last rotation stays valid",
+			},
+		},
+	},
+)
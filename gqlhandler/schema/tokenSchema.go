@@ -9,15 +9,43 @@ var TokenSchema = graphql.NewObject(
 			"tokenName": &graphql.Field{
 				Type: graphql.NewNonNull(graphql.String),
 			},
+			"userName": &graphql.Field{
+				Type: graphql.String,
+			},
 			"createdAt": &graphql.Field{
 				Type: graphql.NewNonNull(graphql.DateTime),
 			},
 			"expiresAt": &graphql.Field{
 				Type: graphql.NewNonNull(graphql.DateTime),
 			},
+			"expiresSoon": &graphql.Field{
+				Type:        graphql.Boolean,
+				Description: "Whether the token expires within the warning period (7 days by default), only set by the Tokens query",
+			},
 			"token": &graphql.Field{
 				Type: graphql.String,
 			},
+			"scopes": &graphql.Field{
+				Type:        graphql.NewList(graphql.NewNonNull(graphql.String)),
+				Description: "Permissions the token is restricted to, null when the token has all the permissions of its user",
+			},
+			"rotatedAt": &graphql.Field{
+				Type: graphql.DateTime,
+			},
+			"previousTokenExpiresAt": &graphql.Field{
+				Type:        graphql.DateTime,
+				Description: "End of the grace period during which the secret replaced by the last rotation stays valid",
+			},
+			"lastUsedAt": &graphql.Field{
+				Type:        graphql.DateTime,
+				Description: "Last time the token was used, null if it was never used. Usage is recorded with a delay of up to a minute.",
+			},
+			"lastUsedIP": &graphql.Field{
+				Type: graphql.String,
+			},
+			"useCount": &graphql.Field{
+				Type: graphql.Int,
+			},
 		},
 	},
 )
@@ -27,6 +27,12 @@ var UserSchema = graphql.NewObject(
 			"subscription": &graphql.Field{
 				Type: SubscriptionTypeEnum,
 			},
+			"deletedAt": &graphql.Field{
+				Type: graphql.DateTime,
+			},
+			"deletedBy": &graphql.Field{
+				Type: graphql.String,
+			},
 		},
 	},
 )
@@ -83,7 +89,7 @@ var UserInputSchema = graphql.NewInputObject(
 				Type: graphql.String,
 			},
 			"subscription": &graphql.InputObjectFieldConfig{
-				Type: SubscriptionTypeEnum,
+				Type:         SubscriptionTypeEnum,
 				DefaultValue: "Free",
 			},
 		},
@@ -106,3 +112,192 @@ var UserAddressInputSchema = graphql.NewInputObject(
 		},
 	},
 )
+
+var UsersConnectionSchema = NewConnectionSchema("Users", UserSchema)
+
+var UserSortByEnum = graphql.NewEnum(graphql.EnumConfig{
+	Name: "UserSortBy",
+	Values: graphql.EnumValueConfigMap{
+		"ID": &graphql.EnumValueConfig{
+			Value: "id",
+		},
+		"NAME": &graphql.EnumValueConfig{
+			Value: "name",
+		},
+		"DOB": &graphql.EnumValueConfig{
+			Value: "dob",
+		},
+	},
+})
+
+var SubscriptionTypeFilterSchema = newScalarFilterSchema("SubscriptionTypeFilter", SubscriptionTypeEnum, false, false)
+
+var UserAddressFilterSchema = graphql.NewInputObject(
+	graphql.InputObjectConfig{
+		Name: "AddressFilter",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"street": &graphql.InputObjectFieldConfig{
+				Type: StringFilterSchema,
+			},
+			"city": &graphql.InputObjectFieldConfig{
+				Type: StringFilterSchema,
+			},
+		},
+	},
+)
+
+var UserFilterSchema *graphql.InputObject
+
+func init() {
+	// UserFilter refers to itself for AND/OR/NOT, so the fields are given as a thunk
+	UserFilterSchema = graphql.NewInputObject(
+		graphql.InputObjectConfig{
+			Name: "UserFilter",
+			Fields: (graphql.InputObjectConfigFieldMapThunk)(func() graphql.InputObjectConfigFieldMap {
+				return graphql.InputObjectConfigFieldMap{
+					"id": &graphql.InputObjectFieldConfig{
+						Type: IntFilterSchema,
+					},
+					"name": &graphql.InputObjectFieldConfig{
+						Type: StringFilterSchema,
+					},
+					"dob": &graphql.InputObjectFieldConfig{
+						Type: DateTimeFilterSchema,
+					},
+					"isVerified": &graphql.InputObjectFieldConfig{
+						Type: BooleanFilterSchema,
+					},
+					"subscription": &graphql.InputObjectFieldConfig{
+						Type: SubscriptionTypeFilterSchema,
+					},
+					"address": &graphql.InputObjectFieldConfig{
+						Type: UserAddressFilterSchema,
+					},
+					"AND": &graphql.InputObjectFieldConfig{
+						Type: graphql.NewList(graphql.NewNonNull(UserFilterSchema)),
+					},
+					"OR": &graphql.InputObjectFieldConfig{
+						Type: graphql.NewList(graphql.NewNonNull(UserFilterSchema)),
+					},
+					"NOT": &graphql.InputObjectFieldConfig{
+						Type: UserFilterSchema,
+					},
+				}
+			}),
+		},
+	)
+}
+
+// Fields of User that can be used in a UserFilter
+var UserFilterFields = []string{"id", "name", "dob", "isVerified", "subscription", "address.city", "address.street"}
+
+var UserPatchInputSchema = graphql.NewInputObject(
+	graphql.InputObjectConfig{
+		Name: "UserPatchInput",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"name": &graphql.InputObjectFieldConfig{
+				Type: graphql.String,
+			},
+			"dob": &graphql.InputObjectFieldConfig{
+				Type: graphql.DateTime,
+			},
+			"address": &graphql.InputObjectFieldConfig{
+				Type: UserAddressPatchInputSchema,
+			},
+			"isVerified": &graphql.InputObjectFieldConfig{
+				Type: graphql.Boolean,
+			},
+			"remarks": &graphql.InputObjectFieldConfig{
+				Type: graphql.String,
+			},
+			"subscription": &graphql.InputObjectFieldConfig{
+				Type: SubscriptionTypeEnum,
+			},
+		},
+	},
+)
+
+var UserAddressPatchInputSchema = graphql.NewInputObject(
+	graphql.InputObjectConfig{
+		Name: "AddressPatchInput",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"block": &graphql.InputObjectFieldConfig{
+				Type: graphql.String,
+			},
+			"street": &graphql.InputObjectFieldConfig{
+				Type: graphql.String,
+			},
+			"city": &graphql.InputObjectFieldConfig{
+				Type: graphql.String,
+			},
+		},
+	},
+)
+
+var UserMutationResultSchema = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name: "UserMutationResult",
+		Fields: graphql.Fields{
+			"id": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.Int),
+			},
+			"user": &graphql.Field{
+				Type:        UserSchema,
+				Description: "The user after the mutation, null if no user with this id exists",
+			},
+			"notFound": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.Boolean),
+			},
+		},
+	},
+)
+
+var DeleteUsersResultSchema = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name: "DeleteUsersResult",
+		Fields: graphql.Fields{
+			"deleted": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(UserSchema))),
+			},
+			"notFoundIds": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.Int))),
+				Description: "Requested ids for which no user exists",
+			},
+		},
+	},
+)
+
+var RestoreUsersResultSchema = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name: "RestoreUsersResult",
+		Fields: graphql.Fields{
+			"restored": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(UserSchema))),
+			},
+			"notFoundIds": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.Int))),
+				Description: "Requested ids for which no deleted user exists",
+			},
+		},
+	},
+)
+
+var UserChangeEventSchema = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name: "UserChangeEvent",
+		Fields: graphql.Fields{
+			"operationType": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.String),
+				Description: "One of insert, update, replace or delete. Soft deletes are sent as deletes and restores as inserts.",
+			},
+			"user": &graphql.Field{
+				Type:        UserSchema,
+				Description: "The user after the change, null for deletes",
+			},
+			"changedFields": &graphql.Field{
+				Type:        graphql.NewList(graphql.NewNonNull(graphql.String)),
+				Description: "Fields updated or removed, only for updates",
+			},
+		},
+	},
+)
@@ -0,0 +1,131 @@
+package subscription
+
+import (
+	"go-graphql-mongo-server/common"
+	"go-graphql-mongo-server/gqlhandler/schema"
+	"go-graphql-mongo-server/logger"
+	"go-graphql-mongo-server/models"
+	"go-graphql-mongo-server/telemetry"
+
+	"github.com/graphql-go/graphql"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var UserChangedSubscription = &graphql.Field{
+	Name:        "userChanged",
+	Type:        graphql.NewNonNull(schema.UserChangeEventSchema),
+	Description: "Get notified when users matching the filter are added, changed or deleted",
+	Args: graphql.FieldConfigArgument{
+		"filter": &graphql.ArgumentConfig{
+			Type:        schema.UserFilterSchema,
+			Description: "Filter on the user after the change. Hard deletes only match when no filter is given.",
+		},
+	},
+	Subscribe: common.RequirePermission(models.PermissionUsersRead, func(p graphql.ResolveParams) (i interface{}, e error) {
+
+		_, err := common.Sanitize(p.Args)
+		if err != nil {
+			return nil, err
+		}
+
+		defer telemetry.LogGraphQlCall(p, e)
+
+		filterInput, _ := p.Args["filter"].(map[string]interface{})
+		filter, err := common.BuildMongoFilter(filterInput, schema.UserFilterFields)
+		if err != nil {
+			return nil, err
+		}
+
+		// Soft deleted users are not visible, except for the update soft deleting them, which is sent as a delete
+		conditions := []interface{}{
+			bson.M{"operationType": bson.M{"$in": []string{"insert", "update", "replace", "delete"}}},
+			bson.M{"$or": []bson.M{
+				{"fullDocument.deletedAt": nil},
+				{"updateDescription.updatedFields.deletedAt": bson.M{"$exists": true}},
+			}},
+		}
+		if len(filter) > 0 {
+			conditions = append(conditions, common.PrefixFilterFields(filter, "fullDocument."))
+		}
+		match := bson.M{"$and": conditions}
+
+		// Only keep the requested fields of the user
+		project := bson.M{"operationType": 1, "updateDescription": 1}
+		for field := range common.BuildProjection(p, []string{"user"}) {
+			project["fullDocument."+field] = 1
+		}
+
+		// Look up the full document for updates, so that the filter can be applied on it
+		changeStream, err := models.Watch(
+			p.Context,
+			models.UserCollection,
+			[]bson.M{{"$match": match}, {"$project": project}},
+			options.ChangeStream().SetFullDocument(options.UpdateLookup),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		events := make(chan interface{})
+		go func() {
+			defer close(events)
+			defer changeStream.Close(p.Context)
+
+			for changeStream.Next(p.Context) {
+				var event models.UserChangeEvent
+				err := changeStream.Decode(&event)
+				if err != nil {
+					logger.Log.Error("Error decoding user change event: " + err.Error())
+					continue
+				}
+
+				event.ChangedFields = event.UpdateDescription.RemovedFields
+				for field := range event.UpdateDescription.UpdatedFields {
+					event.ChangedFields = append(event.ChangedFields, field)
+				}
+				toSoftDeleteEvent(&event)
+
+				select {
+				case events <- event:
+				case <-p.Context.Done():
+					return
+				}
+			}
+
+			if err := changeStream.Err(); err != nil && p.Context.Err() == nil {
+				logger.Log.Error("Error in users change stream: " + err.Error())
+			}
+		}()
+
+		return events, nil
+
+	}),
+	Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+		// The source is the event sent by Subscribe
+		return p.Source, nil
+	},
+}
+
+// Soft deleting a user is sent as a delete, and restoring it as an insert
+func toSoftDeleteEvent(event *models.UserChangeEvent) {
+
+	if event.OperationType != "update" {
+		return
+	}
+
+	if _, deleted := event.UpdateDescription.UpdatedFields["deletedAt"]; deleted {
+		event.OperationType = "delete"
+		event.User = nil
+		event.ChangedFields = nil
+		return
+	}
+
+	for _, field := range event.UpdateDescription.RemovedFields {
+		if field == "deletedAt" {
+			event.OperationType = "insert"
+			event.ChangedFields = nil
+			return
+		}
+	}
+}
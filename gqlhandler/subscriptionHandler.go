@@ -0,0 +1,315 @@
+package gqlhandler
+
+import (
+	"context"
+	"encoding/json"
+	"go-graphql-mongo-server/auth"
+	"go-graphql-mongo-server/common"
+	"go-graphql-mongo-server/logger"
+	"go-graphql-mongo-server/models"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"golang.org/x/net/websocket"
+)
+
+// Implementation of the graphql-transport-ws protocol
+// https://github.com/enisdenjo/graphql-ws/blob/master/PROTOCOL.md
+const (
+	subscriptionProtocol = "graphql-transport-ws"
+
+	connectionInitTimeout = 10 * time.Second
+
+	// Message types
+	msgConnectionInit = "connection_init"
+	msgConnectionAck  = "connection_ack"
+	msgPing           = "ping"
+	msgPong           = "pong"
+	msgSubscribe      = "subscribe"
+	msgNext           = "next"
+	msgError          = "error"
+	msgComplete       = "complete"
+
+	// Close codes
+	closeBadRequest         = 4400
+	closeUnauthorized       = 4401
+	closeForbidden          = 4403
+	closeInitTimeout        = 4408
+	closeSubscriberExists   = 4409
+	closeTooManyInitRequest = 4429
+)
+
+type wsMessage struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+type wsSession struct {
+	conn *websocket.Conn
+
+	lock          sync.Mutex
+	ctx           context.Context
+	isInitialized bool
+	isAcked       bool
+	subscriptions map[string]context.CancelFunc
+}
+
+var subscriptionServer = websocket.Server{
+	// Authentication happens with the connection_init message and not with cookies,
+	// so the origin does not need to be checked here
+	Handshake: func(config *websocket.Config, _ *http.Request) error {
+		for _, protocol := range config.Protocol {
+			if protocol == subscriptionProtocol {
+				config.Protocol = []string{subscriptionProtocol}
+				return nil
+			}
+		}
+		return websocket.ErrBadWebSocketProtocol
+	},
+	Handler: serveSubscriptions,
+}
+
+func SubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	subscriptionServer.ServeHTTP(w, r)
+}
+
+func serveSubscriptions(conn *websocket.Conn) {
+
+	ctx, cancel := context.WithCancel(auth.WithClientCertificate(common.WithClientIP(conn.Request())).Context())
+	defer cancel()
+
+	// All the operations of a connection share the same request ID
+	ctx = context.WithValue(ctx, models.RequestIDContextKey, common.NewRequestID())
+
+	session := &wsSession{
+		conn:          conn,
+		ctx:           ctx,
+		subscriptions: make(map[string]context.CancelFunc),
+	}
+
+	// Close the connection if it is not initialized in time
+	initTimer := time.AfterFunc(connectionInitTimeout, func() {
+		session.lock.Lock()
+		defer session.lock.Unlock()
+		if !session.isAcked {
+			session.close(closeInitTimeout)
+		}
+	})
+	defer initTimer.Stop()
+
+	for {
+		var message wsMessage
+		err := websocket.JSON.Receive(conn, &message)
+		if err != nil {
+			// Either the client went away or sent something that is not JSON
+			if _, isSyntaxError := err.(*json.SyntaxError); isSyntaxError {
+				session.close(closeBadRequest)
+			}
+			return
+		}
+
+		if !session.handleMessage(message) {
+			return
+		}
+	}
+}
+
+// handleMessage processes a client message and returns false when the connection got closed
+func (s *wsSession) handleMessage(message wsMessage) bool {
+
+	switch message.Type {
+
+	case msgConnectionInit:
+		return s.initialize(message.Payload)
+
+	case msgPing:
+		s.send(wsMessage{Type: msgPong})
+
+	case msgPong:
+		// Nothing to do
+
+	case msgSubscribe:
+		return s.subscribe(message)
+
+	case msgComplete:
+		s.lock.Lock()
+		cancel, found := s.subscriptions[message.ID]
+		delete(s.subscriptions, message.ID)
+		s.lock.Unlock()
+		if found {
+			cancel()
+		}
+
+	default:
+		s.close(closeBadRequest)
+		return false
+	}
+
+	return true
+}
+
+// Authenticate the connection with the Authorization in the connection_init payload, or with the client certificate
+func (s *wsSession) initialize(payload json.RawMessage) bool {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.isInitialized {
+		s.close(closeTooManyInitRequest)
+		return false
+	}
+	s.isInitialized = true
+
+	var initPayload map[string]interface{}
+	if len(payload) > 0 {
+		if err := json.Unmarshal(payload, &initPayload); err != nil {
+			s.close(closeBadRequest)
+			return false
+		}
+	}
+
+	authorization, _ := initPayload["Authorization"].(string)
+	if authorization == "" {
+		authorization, _ = initPayload["authorization"].(string)
+	}
+
+	identity, err := auth.Authenticate(s.ctx, authorization)
+	if err != nil {
+		s.close(closeForbidden)
+		return false
+	}
+
+	s.ctx = auth.WithIdentity(s.ctx, identity)
+	s.isAcked = true
+	s.send(wsMessage{Type: msgConnectionAck})
+	return true
+}
+
+func (s *wsSession) subscribe(message wsMessage) bool {
+
+	s.lock.Lock()
+
+	if !s.isAcked {
+		s.close(closeUnauthorized)
+		s.lock.Unlock()
+		return false
+	}
+
+	var request models.GQLRequestBody
+	if message.ID == "" || json.Unmarshal(message.Payload, &request) != nil {
+		s.close(closeBadRequest)
+		s.lock.Unlock()
+		return false
+	}
+
+	if _, exists := s.subscriptions[message.ID]; exists {
+		s.close(closeSubscriberExists)
+		s.lock.Unlock()
+		return false
+	}
+
+	// The id is reserved, the subscription is then set up without the lock as it reads the database, so that a slow
+	// database doesn't block the other messages of the connection. A complete message received meanwhile cancels it.
+	// No loader registry is attached, a subscription lives too long to memoize the documents it reads.
+	ctx, cancel := context.WithCancel(s.ctx)
+	s.subscriptions[message.ID] = cancel
+	s.lock.Unlock()
+
+	newPersistedQuery, persistedQueryErr := resolvePersistedQuery(ctx, &request)
+	if persistedQueryErr != nil {
+		s.rejectSubscription(message.ID, persistedQueryErr.formatted())
+		return true
+	}
+
+	if guestErr := checkGuestAccess(ctx, request); guestErr != nil {
+		s.rejectSubscription(message.ID, *guestErr)
+		return true
+	}
+
+	maxComplexity := getComplexityBudget(ctx)
+	if _, limitErr := checkQueryLimits(request, maxComplexity, maxComplexity); limitErr != nil {
+		s.rejectSubscription(message.ID, limitErr.formatted())
+		return true
+	}
+
+	// Only the operations passing every check are stored, guests can't store persisted queries
+	if newPersistedQuery != nil && !isGuest(ctx) {
+		storePersistedQuery(ctx, *newPersistedQuery)
+	}
+
+	results := graphql.Subscribe(graphql.Params{
+		Schema:         SchemaQl,
+		RequestString:  request.Query,
+		VariableValues: request.Variables,
+		OperationName:  request.OperationName,
+		Context:        ctx,
+	})
+
+	go s.forwardResults(ctx, message.ID, results)
+	return true
+}
+
+// Sends the error of a subscription which failed its checks, and releases its id
+func (s *wsSession) rejectSubscription(id string, formattedError gqlerrors.FormattedError) {
+	payload, _ := json.Marshal([]gqlerrors.FormattedError{formattedError})
+	s.send(wsMessage{ID: id, Type: msgError, Payload: payload})
+	s.removeSubscription(id)
+}
+
+// Sends every result of a subscription to the client until it ends or the client completes it
+func (s *wsSession) forwardResults(ctx context.Context, id string, results chan *graphql.Result) {
+
+	isFirst := true
+	for result := range results {
+		formatErrors(common.GetRequestID(ctx), result.Errors)
+
+		// A failure before any event means the operation could not be executed at all
+		if isFirst && result.HasErrors() && result.Data == nil {
+			// The client may have completed the subscription while it was set up
+			if ctx.Err() == nil {
+				payload, _ := json.Marshal(result.Errors)
+				s.send(wsMessage{ID: id, Type: msgError, Payload: payload})
+			}
+			s.removeSubscription(id)
+			return
+		}
+		isFirst = false
+
+		payload, _ := json.Marshal(result)
+		s.send(wsMessage{ID: id, Type: msgNext, Payload: payload})
+	}
+
+	// Only notify the client if the subscription ended on the server side
+	if ctx.Err() == nil {
+		s.send(wsMessage{ID: id, Type: msgComplete})
+	}
+	s.removeSubscription(id)
+}
+
+func (s *wsSession) removeSubscription(id string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if cancel, found := s.subscriptions[id]; found {
+		cancel()
+		delete(s.subscriptions, id)
+	}
+}
+
+func (s *wsSession) send(message wsMessage) {
+	err := websocket.JSON.Send(s.conn, message)
+	if err != nil {
+		logger.Log.Errorf("Error in writing subscription message %+v", err)
+	}
+}
+
+func (s *wsSession) close(code int) {
+	err := s.conn.WriteClose(code)
+	if err != nil {
+		logger.Log.Errorf("Error in closing subscription connection %+v", err)
+	}
+	s.conn.Close()
+}
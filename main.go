@@ -2,7 +2,11 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"go-graphql-mongo-server/auth"
+	"go-graphql-mongo-server/common"
 	"go-graphql-mongo-server/config"
 	"go-graphql-mongo-server/dbmigration"
 	"go-graphql-mongo-server/logger"
@@ -10,7 +14,9 @@ import (
 	"go-graphql-mongo-server/routes"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/adammck/venv"
@@ -19,6 +25,9 @@ import (
 	"github.com/urfave/negroni"
 )
 
+// Maximum duration for which the running requests are awaited on shutdown
+const shutdownTimeout = 30 * time.Second
+
 type Service struct {
 	HTTPServer http.Server
 }
@@ -31,11 +40,17 @@ func main() {
 	// Initialize Logger
 	logger.Initialize()
 
+	// Validate the client certificate identities, which grant scopes
+	err := auth.ValidateClientCertIdentities()
+	if err != nil {
+		panic(fmt.Errorf("invalid HTTPS_CLIENT_CERT_IDENTITIES : %v", err))
+	}
+
 	// Initialize Database
 	models.InitializeDB()
 
 	//Run DB Migration
-	err := dbmigration.RunDbSchemaMigration("")
+	err = dbmigration.RunDbSchemaMigration("")
 	if err != nil {
 		panic(fmt.Errorf("error while running migration : %v", err))
 	}
@@ -68,9 +83,22 @@ func main() {
 		// TLSNextProto: make(map[string]func(*http.Server, *tls.Conn, http.Handler)),
 	}
 
-	err = service.Run()
+	go func() {
+		err := service.Run()
+		if err != nil && err != http.ErrServerClosed {
+			logger.Log.Fatal("Error starting the server", err)
+		}
+	}()
+
+	// Stop gracefully when the process is interrupted or terminated (Eg. by the container runtime)
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+
+	logger.Log.Info("Shutting down the server")
+	err = service.Shutdown()
 	if err != nil {
-		logger.Log.Fatal("Error starting the server", err)
+		logger.Log.Error("Error shutting down the server", err)
 	}
 
 }
@@ -80,9 +108,20 @@ func (s *Service) Run() error {
 
 	if checkIfHTTPSCertExists() {
 		logger.Log.Info("HTTPS Enabled")
+
+		tlsConfig, err := getClientAuthTLSConfig()
+		if err != nil {
+			return err
+		}
+		s.HTTPServer.TLSConfig = tlsConfig
+
 		return s.HTTPServer.ListenAndServeTLS(config.Store.HTTPSCert.CertFilePath, config.Store.HTTPSCert.KeyFilePath)
 	}
-	
+
+	if config.Store.HTTPSCert.ClientCAFilePath != "" {
+		logger.Log.Error("Client certificates can only be verified with HTTPS, HTTPS_CLIENT_CA_FILE_PATH is ignored")
+	}
+
 	logger.Log.Info("HTTPS Disabled")
 	return s.HTTPServer.ListenAndServe()
 
@@ -107,8 +146,52 @@ func checkIfHTTPSCertExists() bool {
 	return true
 }
 
+// Returns the TLS config verifying the client certificates (mTLS) with the client CA bundle, nil without CA bundle
+func getClientAuthTLSConfig() (*tls.Config, error) {
+	if config.Store.HTTPSCert.ClientCAFilePath == "" {
+		return nil, nil
+	}
+
+	caBundle, err := os.ReadFile(config.Store.HTTPSCert.ClientCAFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading the client CA bundle : %v", err)
+	}
+
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(caBundle) {
+		return nil, fmt.Errorf("no certificate found in the client CA bundle %v", config.Store.HTTPSCert.ClientCAFilePath)
+	}
+
+	var clientAuth tls.ClientAuthType
+	switch config.Store.HTTPSCert.ClientAuth {
+	case "optional":
+		clientAuth = tls.VerifyClientCertIfGiven
+	case "required":
+		clientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("invalid HTTPS_CLIENT_AUTH %v, it must be optional or required", config.Store.HTTPSCert.ClientAuth)
+	}
+
+	logger.Log.Infof("Client certificates are %v", config.Store.HTTPSCert.ClientAuth)
+	return &tls.Config{
+		ClientCAs:  clientCAs,
+		ClientAuth: clientAuth,
+		MinVersion: tls.VersionTLS12,
+	}, nil
+}
+
+// Stops accepting requests and waits for the running ones, at most shutdownTimeout
 func (s *Service) Shutdown() error {
-	if err := s.HTTPServer.Shutdown(context.Background()); err != nil && err != http.ErrServerClosed {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	err := s.HTTPServer.Shutdown(ctx)
+
+	// Write the token usage and the authentications which were not flushed yet
+	auth.FlushTokenUsage()
+	common.FlushAuthentications()
+
+	if err != nil && err != http.ErrServerClosed {
 		return err
 	}
 	return nil
@@ -121,5 +204,22 @@ func setUpCronJobs() {
 	if err != nil {
 		logger.Log.Error(err)
 	}
+	_, err = cronJob.AddFunc("@every 1h", models.PurgeDeletedUsers)
+	if err != nil {
+		logger.Log.Error(err)
+	}
+	_, err = cronJob.AddFunc("@every "+config.Store.TokenUsageFlushInterval, auth.FlushTokenUsage)
+	if err != nil {
+		logger.Log.Error(err)
+	}
+	_, err = cronJob.AddFunc("@every 1m", common.FlushAuthentications)
+	if err != nil {
+		logger.Log.Error(err)
+	}
+	// Only the OIDC public keys which reached the max-age of their Cache-Control are refreshed
+	_, err = cronJob.AddFunc("@every "+config.Store.JWKSMinRefreshInterval, auth.RefreshOIDCInfo)
+	if err != nil {
+		logger.Log.Error(err)
+	}
 	cronJob.Start()
 }
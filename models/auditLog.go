@@ -0,0 +1,186 @@
+package models
+
+import (
+	"context"
+	"go-graphql-mongo-server/config"
+	"go-graphql-mongo-server/logger"
+	"reflect"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Outcomes of an audited operation
+const (
+	AuditOutcomeSuccess = "success"
+	AuditOutcomeFailure = "failure"
+)
+
+// Operation of the authentication entries, the other entries have the operation of the GraphQL request (Eg. mutation)
+const AuditOperationAuthentication = "authentication"
+
+// Value stored instead of the value of a sensitive argument or field
+const AuditRedacted = "[REDACTED]"
+
+// Entries are written with a context detached from the request, so that they are written even when it is canceled,
+// the write is bounded by this timeout instead
+const auditLogWriteTimeout = 5 * time.Second
+
+// An entry of the audit log, which records who changed what
+// The log is append-only by convention: the server only inserts entries, the database does not prevent other writes.
+type AuditLog struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Timestamp time.Time          `json:"timestamp" bson:"timestamp"`
+
+	// User name of the caller, services call the server as the internal user
+	Actor      string `json:"actor" bson:"actor"`
+	Service    string `json:"service,omitempty" bson:"service,omitempty"`
+	AuthMethod string `json:"authMethod,omitempty" bson:"authMethod,omitempty"`
+	ClientIP   string `json:"clientIp,omitempty" bson:"clientIp,omitempty"`
+	RequestID  string `json:"requestId,omitempty" bson:"requestId,omitempty"`
+
+	Operation string `json:"operation" bson:"operation"`
+	Name      string `json:"name" bson:"name"`
+	Args      bson.M `json:"args,omitempty" bson:"args,omitempty"`
+
+	// Fields changed by an update, with their values before and after it
+	Before bson.M `json:"before,omitempty" bson:"before,omitempty"`
+	After  bson.M `json:"after,omitempty" bson:"after,omitempty"`
+
+	Outcome string `json:"outcome" bson:"outcome"`
+	Error   string `json:"error,omitempty" bson:"error,omitempty"`
+
+	// Number of authentications aggregated in the entry, the rejected ones by client IP with the error of the last one
+	// and the successful ones by identity with the client IP of the last one
+	Count int64 `json:"count,omitempty" bson:"count,omitempty"`
+
+	// Entries are removed by a TTL index once expired, they are kept forever without expiry
+	ExpiresAt *time.Time `json:"-" bson:"expiresAt,omitempty"`
+}
+
+// InsertAuditLog appends an entry to the audit log, its expiry is set from the audit log retention
+// Failing to write the audit log is logged, it doesn't fail the audited operation.
+func InsertAuditLog(ctx context.Context, entry AuditLog) {
+
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = time.Now()
+	}
+
+	if config.Store.AuditLogRetention != "" {
+		retention, err := time.ParseDuration(config.Store.AuditLogRetention)
+		if err != nil || retention < 0 {
+			logger.Log.Errorf("Invalid audit log retention %v : %v", config.Store.AuditLogRetention, err)
+		} else if retention > 0 {
+			expiresAt := entry.Timestamp.Add(retention)
+			entry.ExpiresAt = &expiresAt
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, auditLogWriteTimeout)
+	defer cancel()
+
+	err := Insert(ctx, AuditLogCollection, entry)
+	if err != nil {
+		logger.Log.Errorf("Error writing audit log of %v '%v' called by %v: %v", entry.Operation, entry.Name, entry.Actor, err)
+	}
+}
+
+// IsSensitiveAuditField reports whether a field or an argument holds a secret, which must not be written to the audit log
+func IsSensitiveAuditField(name string) bool {
+	name = strings.ToLower(name)
+	for _, sensitive := range []string{"password", "secret", "hash"} {
+		if strings.Contains(name, sensitive) {
+			return true
+		}
+	}
+	return name == "key" || name == "token" || name == "keystring" || name == "tokenstring"
+}
+
+// DiffDocuments returns the fields which differ between two versions of a document, with their values in each version
+// Nested documents are compared field by field and the values of sensitive fields are redacted.
+func DiffDocuments(before interface{}, after interface{}) (bson.M, bson.M, error) {
+
+	beforeDocument, err := toDocument(before)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	afterDocument, err := toDocument(after)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	beforeDiff, afterDiff := diffDocuments(beforeDocument, afterDocument)
+	return beforeDiff, afterDiff, nil
+}
+
+func toDocument(value interface{}) (bson.M, error) {
+
+	if value == nil || (reflect.ValueOf(value).Kind() == reflect.Pointer && reflect.ValueOf(value).IsNil()) {
+		return bson.M{}, nil
+	}
+
+	data, err := bson.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	// Nested documents are also decoded as bson.M, as it is the type of their ancestor
+	document := bson.M{}
+	err = bson.Unmarshal(data, &document)
+	return document, err
+}
+
+func diffDocuments(before bson.M, after bson.M) (bson.M, bson.M) {
+
+	beforeDiff := bson.M{}
+	afterDiff := bson.M{}
+
+	keys := map[string]bool{}
+	for key := range before {
+		keys[key] = true
+	}
+	for key := range after {
+		keys[key] = true
+	}
+
+	for key := range keys {
+		beforeValue, inBefore := before[key]
+		afterValue, inAfter := after[key]
+
+		beforeNested, isBeforeNested := beforeValue.(bson.M)
+		afterNested, isAfterNested := afterValue.(bson.M)
+		if isBeforeNested && isAfterNested {
+			nestedBefore, nestedAfter := diffDocuments(beforeNested, afterNested)
+			if len(nestedBefore) > 0 {
+				beforeDiff[key] = nestedBefore
+			}
+			if len(nestedAfter) > 0 {
+				afterDiff[key] = nestedAfter
+			}
+			continue
+		}
+
+		if inBefore == inAfter && reflect.DeepEqual(beforeValue, afterValue) {
+			continue
+		}
+
+		if inBefore {
+			beforeDiff[key] = redactAuditValue(key, beforeValue)
+		}
+		if inAfter {
+			afterDiff[key] = redactAuditValue(key, afterValue)
+		}
+	}
+
+	return beforeDiff, afterDiff
+}
+
+func redactAuditValue(name string, value interface{}) interface{} {
+	if IsSensitiveAuditField(name) {
+		return AuditRedacted
+	}
+	return value
+}
@@ -6,14 +6,34 @@ const (
 	PermissionDenied = "permission denied"
 
 	// Context Keys
-	UserContextKey = contextKey("User")
+	UserContextKey       = contextKey("User")
+	RequestIDContextKey  = contextKey("RequestID")
+	LoadersContextKey    = contextKey("Loaders")
+	ScopesContextKey     = contextKey("Scopes")
+	ClientIPContextKey   = contextKey("ClientIP")
+	ServiceContextKey    = contextKey("Service")
+	ClientCertContextKey = contextKey("ClientCert")
+	AuthMethodContextKey = contextKey("AuthMethod")
+	AuditLogContextKey   = contextKey("AuditLog")
 
 	// Users
 	InternalUser = "__INTERNAL__"
 	GuestUser    = "__GUEST__"
 
+	// Authentication methods
+	AuthMethodOIDC       = "oidc"
+	AuthMethodPAT        = "pat"
+	AuthMethodServiceKey = "service_key"
+	AuthMethodMTLS       = "mtls"
+	AuthMethodGuest      = "guest"
+
 	//Collection Names
 	UserCollection            = "users"
 	SchemaMigrationCollection = "schema_migrations"
 	TokenCollection           = "tokens"
+	PersistedQueryCollection  = "persisted_queries"
+	RoleCollection            = "roles"
+	RoleBindingCollection     = "role_bindings"
+	ServiceKeyCollection      = "service_keys"
+	AuditLogCollection        = "audit_log"
 )
@@ -5,6 +5,7 @@ import (
 	"crypto/tls"
 	"errors"
 	"fmt"
+	"go-graphql-mongo-server/apperror"
 	"go-graphql-mongo-server/config"
 	"go-graphql-mongo-server/logger"
 	"strings"
@@ -15,6 +16,11 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+var (
+	ErrNoDocumentFound    = apperror.New(apperror.NotFound, "no document found")
+	ErrNoDocumentModified = errors.New("no document modified")
+)
+
 var once sync.Once
 var dbSession *mongo.Client
 var dbName string
@@ -168,14 +174,20 @@ func UpdateWithOptions(ctx context.Context, collectionName string, filter interf
 	res, err := getCollection(collectionName).UpdateOne(ctx, filter, update, options)
 	if err != nil {
 		logger.Log.Error("Error updating document: " + err.Error())
+		return err
+	}
+
+	// Check if anything matched the filter
+	if res.MatchedCount == 0 && res.UpsertedCount == 0 {
+		return ErrNoDocumentFound
 	}
 
 	// Check if anything got modified or upserted
 	if res.ModifiedCount == 0 && res.UpsertedCount == 0 {
-		return errors.New("no document modified")
+		return ErrNoDocumentModified
 	}
 
-	return err
+	return nil
 
 }
 
@@ -185,19 +197,41 @@ func Update(ctx context.Context, collectionName string, filter interface{}, upda
 
 }
 
+// Replace replaces the whole document matching the filter, it returns ErrNoDocumentFound when nothing matched
+func Replace(ctx context.Context, collectionName string, filter interface{}, replacement interface{}) error {
+
+	res, err := getCollection(collectionName).ReplaceOne(ctx, filter, replacement)
+	if err != nil {
+		logger.Log.Error("Error replacing document: " + err.Error())
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return ErrNoDocumentFound
+	}
+
+	if res.ModifiedCount == 0 {
+		return ErrNoDocumentModified
+	}
+
+	return nil
+
+}
+
 func Upsert(ctx context.Context, collectionName string, filter interface{}, update interface{}) error {
 
 	return UpdateWithOptions(ctx, collectionName, filter, update, options.Update().SetUpsert(true))
 
 }
 
-func UpdateMany(ctx context.Context, collectionName string, filter interface{}, update interface{}) error {
+func UpdateMany(ctx context.Context, collectionName string, filter interface{}, update interface{}) (int64, error) {
 
-	_, err := getCollection(collectionName).UpdateMany(ctx, filter, update)
+	res, err := getCollection(collectionName).UpdateMany(ctx, filter, update)
 	if err != nil {
 		logger.Log.Error("Error updating documents: " + err.Error())
+		return 0, err
 	}
-	return err
+	return res.ModifiedCount, nil
 
 }
 
@@ -211,13 +245,14 @@ func Delete(ctx context.Context, collectionName string, filter interface{}) erro
 
 }
 
-func DeleteMany(ctx context.Context, collectionName string, filter interface{}) error {
+func DeleteMany(ctx context.Context, collectionName string, filter interface{}) (int64, error) {
 
-	_, err := getCollection(collectionName).DeleteMany(ctx, filter)
+	res, err := getCollection(collectionName).DeleteMany(ctx, filter)
 	if err != nil {
 		logger.Log.Error("Error deleting documents: " + err.Error())
+		return 0, err
 	}
-	return err
+	return res.DeletedCount, nil
 
 }
 
@@ -241,3 +276,146 @@ func FindDistinct(ctx context.Context, collectionName string, fieldName string,
 	return result, nil
 
 }
+
+// Generic change stream on a MongoDB collection
+// The caller is responsible for closing the returned change stream.
+func Watch(ctx context.Context, collectionName string, pipeline interface{}, opts ...*options.ChangeStreamOptions) (*mongo.ChangeStream, error) {
+
+	changeStream, err := getCollection(collectionName).Watch(ctx, pipeline, opts...)
+	if err != nil {
+		logger.Log.Error("Error watching collection: " + err.Error())
+	}
+	return changeStream, err
+
+}
+
+func Count(ctx context.Context, collectionName string, filter interface{}) (int64, error) {
+
+	if filter == nil {
+		filter = bson.M{}
+	}
+
+	count, err := getCollection(collectionName).CountDocuments(ctx, filter)
+	if err != nil {
+		logger.Log.Error("Error counting documents: " + err.Error())
+	}
+	return count, err
+
+}
+
+// Generic keyset (cursor based) paginated Find from MongoDB
+// It never uses skip, instead the cursor of the boundary document is converted to a range filter
+// on (sortField, _id), so that the query can be served by an index on those fields.
+func FindPage[T any](ctx context.Context, collectionName string, filter interface{}, projection interface{}, pageOptions PageOptions) (*Page[T], error) {
+
+	if filter == nil {
+		filter = bson.M{}
+	}
+
+	err := pageOptions.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	// Add the cursor boundaries to the filter
+	conditions := []interface{}{filter}
+	if pageOptions.After != "" {
+		afterFilter, err := pageOptions.cursorFilter(pageOptions.After, !pageOptions.Descending)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, afterFilter)
+	}
+	if pageOptions.Before != "" {
+		beforeFilter, err := pageOptions.cursorFilter(pageOptions.Before, pageOptions.Descending)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, beforeFilter)
+	}
+
+	pageFilter := filter
+	if len(conditions) > 1 {
+		pageFilter = bson.M{"$and": conditions}
+	}
+
+	// When paginating backwards, read in the reverse order and flip the result afterwards
+	backward := pageOptions.Last > 0
+	limit := pageOptions.First
+	if backward {
+		limit = pageOptions.Last
+	}
+
+	sortDirection := 1
+	if pageOptions.Descending != backward {
+		sortDirection = -1
+	}
+	sort := bson.D{{Key: pageOptions.SortField, Value: sortDirection}}
+	if pageOptions.SortField != "_id" {
+		sort = append(sort, bson.E{Key: "_id", Value: sortDirection})
+	}
+
+	// Fetch one extra document to know whether there is another page
+	findOptions := options.Find().
+		SetProjection(projection).
+		SetSort(sort).
+		SetLimit(int64(limit + 1))
+
+	cursor, err := getCollection(collectionName).Find(ctx, pageFilter, findOptions)
+	if err != nil {
+		logger.Log.Error("Error finding documents: " + err.Error())
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	edges := []Edge[T]{}
+	for cursor.Next(ctx) {
+		var edge Edge[T]
+		err = cursor.Decode(&edge.Node)
+		if err != nil {
+			logger.Log.Error("Error decoding documents: " + err.Error())
+			return nil, err
+		}
+
+		edge.Cursor, err = pageOptions.encodeCursor(cursor.Current)
+		if err != nil {
+			logger.Log.Error("Error encoding cursor: " + err.Error())
+			return nil, err
+		}
+		edges = append(edges, edge)
+	}
+	if err = cursor.Err(); err != nil {
+		logger.Log.Error("Error iterating documents: " + err.Error())
+		return nil, err
+	}
+
+	hasMore := len(edges) > limit
+	if hasMore {
+		edges = edges[:limit]
+	}
+
+	page := &Page[T]{
+		collectionName: collectionName,
+		filter:         filter,
+	}
+
+	if backward {
+		for i, j := 0, len(edges)-1; i < j; i, j = i+1, j-1 {
+			edges[i], edges[j] = edges[j], edges[i]
+		}
+		page.PageInfo.HasPreviousPage = hasMore
+		page.PageInfo.HasNextPage = pageOptions.Before != ""
+	} else {
+		page.PageInfo.HasNextPage = hasMore
+		page.PageInfo.HasPreviousPage = pageOptions.After != ""
+	}
+
+	page.Edges = edges
+	if len(edges) > 0 {
+		page.PageInfo.StartCursor = edges[0].Cursor
+		page.PageInfo.EndCursor = edges[len(edges)-1].Cursor
+	}
+
+	return page, nil
+
+}
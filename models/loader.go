@@ -0,0 +1,259 @@
+package models
+
+import (
+	"context"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Maximum number of keys sent in a single $in query, bigger batches are split
+const maxLoaderBatchSize = 1000
+
+// Loader batches and memoizes the reads of documents by key for the lifetime of a request (DataLoader pattern)
+//
+// Load only registers the key and returns a thunk. graphql-go calls the thunks returned by resolvers after
+// all the sibling fields are resolved, so the keys requested by a whole level of the query are read with a
+// single $in query when the first thunk is called. Calling the thunk within the resolver defeats the batching.
+type Loader[K comparable, V any] struct {
+	collectionName string
+	keyField       string
+	filter         bson.M
+	keyOf          func(*V) K
+
+	// Context of the registry the loader belongs to, a batch is shared by several callers so it must not be
+	// read with the context of the caller which happens to dispatch it, and fail when that caller times out
+	dispatchCtx context.Context
+
+	lock    sync.Mutex
+	results map[K]*loaderResult[V]
+	pending []pendingKey[K, V]
+}
+
+type pendingKey[K comparable, V any] struct {
+	key    K
+	result *loaderResult[V]
+}
+
+type loaderResult[V any] struct {
+	done  chan struct{}
+	value *V
+	err   error
+}
+
+// NewLoader creates a loader reading the documents of a collection whose keyField is one of the requested keys
+// The filter is added to every query, keyOf must return the key of a decoded document.
+func NewLoader[K comparable, V any](collectionName string, keyField string, filter bson.M, keyOf func(*V) K) *Loader[K, V] {
+	return &Loader[K, V]{
+		collectionName: collectionName,
+		keyField:       keyField,
+		filter:         filter,
+		keyOf:          keyOf,
+		results:        map[K]*loaderResult[V]{},
+	}
+}
+
+// Load returns a thunk resolving to the document with the given key, or nil if it doesn't exist
+// Resolvers must return the thunk as is, so that graphql-go calls it once the sibling fields registered their keys.
+func (l *Loader[K, V]) Load(ctx context.Context, key K) func() (interface{}, error) {
+
+	result := l.register(key)
+
+	return func() (interface{}, error) {
+		return l.await(ctx, result)
+	}
+}
+
+// LoadMany returns a thunk resolving to the documents with the given keys, in the same order
+// Keys without a document have a nil entry.
+func (l *Loader[K, V]) LoadMany(ctx context.Context, keys []K) func() (interface{}, error) {
+
+	results := l.registerMany(keys)
+
+	return func() (interface{}, error) {
+		return l.awaitMany(ctx, results)
+	}
+}
+
+// Get reads the document with the given key right away, or nil if it doesn't exist
+// It is meant for the code which needs the document itself, the keys pending in the loader are read in the same batch.
+func (l *Loader[K, V]) Get(ctx context.Context, key K) (*V, error) {
+	return l.await(ctx, l.register(key))
+}
+
+// GetMany reads the documents with the given keys right away, in the same order
+// Keys without a document have a nil entry.
+func (l *Loader[K, V]) GetMany(ctx context.Context, keys []K) ([]*V, error) {
+	return l.awaitMany(ctx, l.registerMany(keys))
+}
+
+// Prime adds a document which was already read to the cache
+func (l *Loader[K, V]) Prime(value *V) {
+
+	result := &loaderResult[V]{done: make(chan struct{}), value: value}
+	close(result.done)
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if _, ok := l.results[l.keyOf(value)]; !ok {
+		l.results[l.keyOf(value)] = result
+	}
+}
+
+// Clear removes a key from the cache, it must be called after the document is modified
+func (l *Loader[K, V]) Clear(key K) {
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	delete(l.results, key)
+}
+
+// Returns the memoized result of a key, the key is added to the next batch if it was never requested
+func (l *Loader[K, V]) register(key K) *loaderResult[V] {
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	result, ok := l.results[key]
+	if !ok {
+		result = &loaderResult[V]{done: make(chan struct{})}
+		l.results[key] = result
+		l.pending = append(l.pending, pendingKey[K, V]{key, result})
+	}
+	return result
+}
+
+func (l *Loader[K, V]) registerMany(keys []K) []*loaderResult[V] {
+
+	results := make([]*loaderResult[V], len(keys))
+	for i, key := range keys {
+		results[i] = l.register(key)
+	}
+	return results
+}
+
+// Dispatches the pending keys and waits for a result, or until the context of the caller is done
+func (l *Loader[K, V]) await(ctx context.Context, result *loaderResult[V]) (*V, error) {
+
+	l.dispatch(ctx)
+	select {
+	case <-result.done:
+		return result.value, result.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (l *Loader[K, V]) awaitMany(ctx context.Context, results []*loaderResult[V]) ([]*V, error) {
+
+	l.dispatch(ctx)
+
+	values := make([]*V, len(results))
+	for i, result := range results {
+		select {
+		case <-result.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if result.err != nil {
+			return nil, result.err
+		}
+		values[i] = result.value
+	}
+	return values, nil
+}
+
+// Reads all the pending keys, the results of the keys taken by a concurrent dispatch are awaited by the caller
+// The keys are read with the context of the registry when there is one, the one of the caller otherwise.
+func (l *Loader[K, V]) dispatch(ctx context.Context) {
+
+	if l.dispatchCtx != nil {
+		ctx = l.dispatchCtx
+	}
+
+	l.lock.Lock()
+	pending := l.pending
+	l.pending = nil
+	l.lock.Unlock()
+
+	for start := 0; start < len(pending); start += maxLoaderBatchSize {
+		end := start + maxLoaderBatchSize
+		if end > len(pending) {
+			end = len(pending)
+		}
+		l.loadBatch(ctx, pending[start:end])
+	}
+}
+
+func (l *Loader[K, V]) loadBatch(ctx context.Context, pending []pendingKey[K, V]) {
+
+	keys := make([]K, len(pending))
+	for i := range pending {
+		keys[i] = pending[i].key
+	}
+
+	filter := bson.M{}
+	for field, value := range l.filter {
+		filter[field] = value
+	}
+	filter[l.keyField] = bson.M{"$in": keys}
+
+	var documents []V
+	err := FindAll(ctx, l.collectionName, filter, nil, &documents)
+
+	values := make(map[K]*V, len(documents))
+	for i := range documents {
+		values[l.keyOf(&documents[i])] = &documents[i]
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	for _, request := range pending {
+		request.result.value, request.result.err = values[request.key], err
+
+		// Errors are not memoized, so that the key can be requested again
+		if err != nil && l.results[request.key] == request.result {
+			delete(l.results, request.key)
+		}
+		close(request.result.done)
+	}
+}
+
+// Request scoped registry of loaders
+type loaderRegistry struct {
+	ctx     context.Context
+	lock    sync.Mutex
+	loaders map[string]interface{}
+}
+
+// WithLoaders returns a copy of the context carrying an empty loader registry
+// Loaders are memoizing every document they read, so the context must not outlive the request.
+// The loaders read the documents with the given context, so it must be the one of the whole request
+// and not the one of an operation of a batch, whose timeout would fail the other operations waiting for the same read.
+func WithLoaders(ctx context.Context) context.Context {
+	return context.WithValue(ctx, LoadersContextKey, &loaderRegistry{ctx: ctx, loaders: map[string]interface{}{}})
+}
+
+// GetLoader returns the loader registered under the given name in the context, creating it if needed
+// Without a registry in the context a new loader is returned every time, so nothing is batched or memoized.
+func GetLoader[K comparable, V any](ctx context.Context, name string, newLoader func() *Loader[K, V]) *Loader[K, V] {
+
+	registry, ok := ctx.Value(LoadersContextKey).(*loaderRegistry)
+	if !ok {
+		return newLoader()
+	}
+
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	loader, ok := registry.loaders[name].(*Loader[K, V])
+	if !ok {
+		loader = newLoader()
+		loader.dispatchCtx = registry.ctx
+		registry.loaders[name] = loader
+	}
+	return loader
+}
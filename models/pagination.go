@@ -0,0 +1,187 @@
+package models
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"go-graphql-mongo-server/apperror"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 500
+)
+
+var ErrInvalidCursor = apperror.New(apperror.BadUserInput, "invalid cursor")
+
+// PageOptions describes a Relay style page request
+// Only one of First or Last can be set. After and Before are opaque cursors returned in a previous page.
+type PageOptions struct {
+	SortField  string
+	Descending bool
+	First      int
+	Last       int
+	After      string
+	Before     string
+}
+
+type PageInfo struct {
+	HasNextPage     bool   `json:"hasNextPage"`
+	HasPreviousPage bool   `json:"hasPreviousPage"`
+	StartCursor     string `json:"startCursor"`
+	EndCursor       string `json:"endCursor"`
+}
+
+type Edge[T any] struct {
+	Node   T      `json:"node"`
+	Cursor string `json:"cursor"`
+}
+
+type Page[T any] struct {
+	Edges    []Edge[T] `json:"edges"`
+	PageInfo PageInfo  `json:"pageInfo"`
+
+	// Used to lazily count the total number of documents matching the filter
+	collectionName string
+	filter         interface{}
+}
+
+// TotalCount runs a count on the filter the page was built from
+// It is only called when a client explicitly asks for it, as counting can be expensive on big collections.
+func (p *Page[T]) TotalCount(ctx context.Context) (int64, error) {
+	return Count(ctx, p.collectionName, p.filter)
+}
+
+// Cursor content, the value of the sort field and the _id of the document for tie breaking
+// The sort is part of the cursor, so that a cursor can't be reused with another sort.
+type pageCursor struct {
+	Value      bson.RawValue `bson:"v"`
+	ID         bson.RawValue `bson:"i"`
+	SortField  string        `bson:"s"`
+	Descending bool          `bson:"d"`
+}
+
+// Types of the values a cursor can hold, documents and arrays could carry query operators into the filter
+var cursorValueTypes = map[bsontype.Type]bool{
+	bson.TypeNull:       true,
+	bson.TypeBoolean:    true,
+	bson.TypeInt32:      true,
+	bson.TypeInt64:      true,
+	bson.TypeDouble:     true,
+	bson.TypeDecimal128: true,
+	bson.TypeString:     true,
+	bson.TypeDateTime:   true,
+	bson.TypeTimestamp:  true,
+	bson.TypeObjectID:   true,
+}
+
+func (o *PageOptions) validate() error {
+
+	if o.First < 0 || o.Last < 0 {
+		return apperror.New(apperror.BadUserInput, "first and last must be non-negative")
+	}
+
+	if o.First > 0 && o.Last > 0 {
+		return apperror.New(apperror.BadUserInput, "first and last can not be used together")
+	}
+
+	if o.First == 0 && o.Last == 0 {
+		o.First = DefaultPageSize
+	}
+
+	if o.First > MaxPageSize || o.Last > MaxPageSize {
+		return apperror.Newf(apperror.BadUserInput, "page size can not be more than %d", MaxPageSize)
+	}
+
+	if o.SortField == "" {
+		o.SortField = "_id"
+	}
+
+	return nil
+}
+
+// Builds a range filter for documents strictly after (or before, when isGreater is false) the cursor
+func (o *PageOptions) cursorFilter(encodedCursor string, isGreater bool) (bson.M, error) {
+
+	cursor, err := decodeCursor(encodedCursor)
+	if err != nil {
+		return nil, err
+	}
+
+	if cursor.SortField != o.SortField || cursor.Descending != o.Descending {
+		return nil, apperror.New(apperror.BadUserInput, "cursor was returned for another sort")
+	}
+
+	operator := "$lt"
+	if isGreater {
+		operator = "$gt"
+	}
+
+	if o.SortField == "_id" {
+		return bson.M{"_id": bson.M{operator: cursor.ID}}, nil
+	}
+
+	// Comparison operators never match null or missing values, which MongoDB sorts before every other value,
+	// so they are matched explicitly
+	if cursor.Value.Type == bson.TypeNull {
+		conditions := []bson.M{{o.SortField: nil, "_id": bson.M{operator: cursor.ID}}}
+		if isGreater {
+			conditions = append(conditions, bson.M{o.SortField: bson.M{"$ne": nil}})
+		}
+		return bson.M{"$or": conditions}, nil
+	}
+
+	conditions := []bson.M{
+		{o.SortField: bson.M{operator: cursor.Value}},
+		{o.SortField: cursor.Value, "_id": bson.M{operator: cursor.ID}},
+	}
+	if !isGreater {
+		conditions = append(conditions, bson.M{o.SortField: nil})
+	}
+	return bson.M{"$or": conditions}, nil
+}
+
+func (o *PageOptions) encodeCursor(document bson.Raw) (string, error) {
+
+	sortField := o.SortField
+	cursor := pageCursor{SortField: sortField, Descending: o.Descending}
+
+	// Missing fields are treated as null, which is also how MongoDB sorts them
+	cursor.Value = bson.RawValue{Type: bson.TypeNull}
+	if value, err := document.LookupErr(sortField); err == nil {
+		cursor.Value = value
+	}
+
+	id, err := document.LookupErr("_id")
+	if err != nil {
+		return "", fmt.Errorf("document has no _id: %v", err)
+	}
+	cursor.ID = id
+
+	cursorBytes, err := bson.Marshal(cursor)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(cursorBytes), nil
+}
+
+func decodeCursor(encodedCursor string) (pageCursor, error) {
+
+	var cursor pageCursor
+
+	cursorBytes, err := base64.RawURLEncoding.DecodeString(encodedCursor)
+	if err != nil {
+		return cursor, ErrInvalidCursor
+	}
+
+	err = bson.Unmarshal(cursorBytes, &cursor)
+	if err != nil || !cursorValueTypes[cursor.ID.Type] || !cursorValueTypes[cursor.Value.Type] {
+		return cursor, ErrInvalidCursor
+	}
+
+	return cursor, nil
+}
@@ -0,0 +1,156 @@
+package models
+
+import (
+	"encoding/base64"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+
+	id := primitive.NewObjectID()
+	createdAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		options    PageOptions
+		document   bson.M
+		wantValue  interface{}
+		wantIsNull bool
+	}{
+		{name: "string", options: PageOptions{SortField: "name"}, document: bson.M{"_id": id, "name": "Alice"}, wantValue: "Alice"},
+		{name: "int", options: PageOptions{SortField: "age", Descending: true}, document: bson.M{"_id": id, "age": int32(42)}, wantValue: int32(42)},
+		{name: "date", options: PageOptions{SortField: "createdAt"}, document: bson.M{"_id": id, "createdAt": createdAt}, wantValue: primitive.NewDateTimeFromTime(createdAt)},
+		{name: "null field", options: PageOptions{SortField: "name"}, document: bson.M{"_id": id, "name": nil}, wantIsNull: true},
+		{name: "missing field", options: PageOptions{SortField: "name"}, document: bson.M{"_id": id}, wantIsNull: true},
+		{name: "_id", options: PageOptions{SortField: "_id"}, document: bson.M{"_id": id}, wantValue: id},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			document, err := bson.Marshal(test.document)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			encoded, err := test.options.encodeCursor(document)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			cursor, err := decodeCursor(encoded)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if cursor.SortField != test.options.SortField || cursor.Descending != test.options.Descending {
+				t.Errorf("got sort %v %v, want %v %v", cursor.SortField, cursor.Descending, test.options.SortField, test.options.Descending)
+			}
+			if cursor.ID.ObjectID() != id {
+				t.Errorf("got id %v, want %v", cursor.ID, id)
+			}
+
+			if test.wantIsNull {
+				if cursor.Value.Type != bson.TypeNull {
+					t.Errorf("got value %v, want null", cursor.Value)
+				}
+				return
+			}
+
+			var value interface{}
+			if err := cursor.Value.Unmarshal(&value); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(value, test.wantValue) {
+				t.Errorf("got value %#v, want %#v", value, test.wantValue)
+			}
+		})
+	}
+}
+
+func TestDecodeCursorRejections(t *testing.T) {
+
+	encode := func(cursor interface{}) string {
+		cursorBytes, err := bson.Marshal(cursor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(cursorBytes)
+	}
+
+	tests := []struct {
+		name   string
+		cursor string
+	}{
+		{name: "not base64", cursor: "!!!"},
+		{name: "not bson", cursor: base64.RawURLEncoding.EncodeToString([]byte("cursor"))},
+		{name: "value with a query operator", cursor: encode(bson.M{"v": bson.M{"$gt": ""}, "i": primitive.NewObjectID(), "s": "name"})},
+		{name: "value which is an array", cursor: encode(bson.M{"v": bson.A{1}, "i": primitive.NewObjectID(), "s": "name"})},
+		{name: "id with a query operator", cursor: encode(bson.M{"v": "Alice", "i": bson.M{"$ne": nil}, "s": "name"})},
+		{name: "missing id", cursor: encode(bson.M{"v": "Alice", "s": "name"})},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := decodeCursor(test.cursor); err != ErrInvalidCursor {
+				t.Errorf("got error %v, want %v", err, ErrInvalidCursor)
+			}
+		})
+	}
+}
+
+func TestCursorFilter(t *testing.T) {
+
+	id := primitive.NewObjectID()
+	options := PageOptions{SortField: "name"}
+
+	encode := func(document bson.M) string {
+		documentBytes, err := bson.Marshal(document)
+		if err != nil {
+			t.Fatal(err)
+		}
+		encoded, err := options.encodeCursor(documentBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return encoded
+	}
+
+	tests := []struct {
+		name      string
+		cursor    string
+		isGreater bool
+		want      int
+	}{
+		// Documents after a value, and documents before it including the null ones
+		{name: "after a value", cursor: encode(bson.M{"_id": id, "name": "Alice"}), isGreater: true, want: 2},
+		{name: "before a value", cursor: encode(bson.M{"_id": id, "name": "Alice"}), isGreater: false, want: 3},
+		// Null values sort first, so every non null value comes after them
+		{name: "after a null value", cursor: encode(bson.M{"_id": id}), isGreater: true, want: 2},
+		{name: "before a null value", cursor: encode(bson.M{"_id": id}), isGreater: false, want: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filter, err := options.cursorFilter(test.cursor, test.isGreater)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			conditions, _ := filter["$or"].([]bson.M)
+			if len(conditions) != test.want {
+				t.Errorf("got %d conditions in %v, want %d", len(conditions), filter, test.want)
+			}
+		})
+	}
+
+	t.Run("cursor of another sort", func(t *testing.T) {
+		descending := PageOptions{SortField: "name", Descending: true}
+		if _, err := descending.cursorFilter(encode(bson.M{"_id": id, "name": "Alice"}), true); err == nil {
+			t.Error("got no error, want an error")
+		}
+	})
+}
@@ -0,0 +1,30 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// Maximum length of a persisted document
+const MaxPersistedQueryLength = 100000
+
+// A GraphQL document stored by its SHA256 hash
+// Documents registered through a manifest are safelisted, the ones stored by Automatic Persisted Queries are not.
+type PersistedQuery struct {
+	Hash          string    `json:"sha256Hash" bson:"hash"`
+	Query         string    `json:"query" bson:"query"`
+	OperationName string    `json:"operationName" bson:"operationName,omitempty"`
+	Safelisted    bool      `json:"safelisted" bson:"safelisted"`
+	CreatedAt     time.Time `json:"createdAt" bson:"createdAt"`
+	CreatedBy     string    `json:"createdBy" bson:"createdBy,omitempty"`
+
+	// Documents stored by Automatic Persisted Queries are removed by a TTL index once expired, safelisted ones never expire
+	ExpiresAt *time.Time `json:"-" bson:"expiresAt,omitempty"`
+}
+
+// PersistedQueryHash returns the hex encoded SHA256 hash of a document, as used by Apollo clients
+func PersistedQueryHash(query string) string {
+	hash := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,128 @@
+package models
+
+import (
+	"context"
+	"go-graphql-mongo-server/config"
+	"strings"
+	"time"
+)
+
+// Permissions granted by roles
+const (
+	PermissionUsersRead   = "users:read"
+	PermissionUsersWrite  = "users:write"
+	PermissionTokensAdmin = "tokens:admin"
+	PermissionRolesAdmin  = "roles:admin"
+
+	PermissionServiceKeysAdmin = "serviceKeys:admin"
+	PermissionAuditRead        = "audit:read"
+
+	PermissionPersistedQueriesWrite = "persistedQueries:write"
+)
+
+// Every permission, which are also the valid scopes of a personal access token
+var Permissions = []string{PermissionUsersRead, PermissionUsersWrite, PermissionTokensAdmin, PermissionRolesAdmin, PermissionServiceKeysAdmin, PermissionAuditRead, PermissionPersistedQueriesWrite}
+
+// A named set of permissions, roles are created by the migrations
+type Role struct {
+	Name        string   `json:"name" bson:"name"`
+	Description string   `json:"description" bson:"description"`
+	Permissions []string `json:"permissions" bson:"permissions"`
+}
+
+// The roles granted to a user, there is at most one binding document per user
+type RoleBinding struct {
+	UserName  string    `json:"userName" bson:"userName"`
+	Roles     []string  `json:"roles" bson:"roles"`
+	UpdatedAt time.Time `json:"updatedAt" bson:"updatedAt"`
+	UpdatedBy string    `json:"updatedBy" bson:"updatedBy"`
+}
+
+// RoleLoader returns the request scoped loader of the roles, by name
+func RoleLoader(ctx context.Context) *Loader[string, Role] {
+	return GetLoader(ctx, RoleCollection, func() *Loader[string, Role] {
+		return NewLoader(RoleCollection, "name", nil, func(role *Role) string { return role.Name })
+	})
+}
+
+// RoleBindingLoader returns the request scoped loader of the role bindings, by user name
+func RoleBindingLoader(ctx context.Context) *Loader[string, RoleBinding] {
+	return GetLoader(ctx, RoleBindingCollection, func() *Loader[string, RoleBinding] {
+		return NewLoader(RoleBindingCollection, "userName", nil, func(binding *RoleBinding) string { return binding.UserName })
+	})
+}
+
+// GetPermissions returns the permissions of a user, from its role bindings and the default roles of every user
+// The internal user has every permission.
+func GetPermissions(ctx context.Context, userName string) (map[string]bool, error) {
+
+	permissions := map[string]bool{}
+	if userName == InternalUser {
+		for _, permission := range Permissions {
+			permissions[permission] = true
+		}
+		return permissions, nil
+	}
+
+	binding, err := RoleBindingLoader(ctx).Get(ctx, userName)
+	if err != nil {
+		return nil, err
+	}
+
+	roleNames := defaultRoles()
+	if binding != nil {
+		roleNames = append(roleNames, binding.Roles...)
+	}
+
+	roles, err := RoleLoader(ctx).GetMany(ctx, roleNames)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, role := range roles {
+		// Bindings to a role which doesn't exist anymore don't grant anything
+		if role == nil {
+			continue
+		}
+		for _, permission := range role.Permissions {
+			permissions[permission] = true
+		}
+	}
+	return permissions, nil
+}
+
+// IsReservedUserName reports whether a user name is one of the identities given by the server itself (Eg. the internal user)
+// Users authenticated by an external identity provider or managed by an admin must not get them.
+func IsReservedUserName(userName string) bool {
+	return userName == InternalUser || userName == GuestUser
+}
+
+// IsPermission reports whether a permission exists
+func IsPermission(permission string) bool {
+	for _, existing := range Permissions {
+		if existing == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// HasPermission reports whether a user is granted a permission
+func HasPermission(ctx context.Context, userName string, permission string) (bool, error) {
+	permissions, err := GetPermissions(ctx, userName)
+	if err != nil {
+		return false, err
+	}
+	return permissions[permission], nil
+}
+
+// Roles implicitly granted to every authenticated user
+func defaultRoles() []string {
+	var roles []string
+	for _, role := range strings.Split(config.Store.DefaultRoles, ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
@@ -0,0 +1,63 @@
+package models
+
+import (
+	"context"
+	"testing"
+)
+
+func TestIsPermission(t *testing.T) {
+
+	tests := []struct {
+		permission string
+		want       bool
+	}{
+		{permission: PermissionUsersRead, want: true},
+		{permission: PermissionServiceKeysAdmin, want: true},
+		{permission: PermissionPersistedQueriesWrite, want: true},
+		{permission: "users:*", want: false},
+		{permission: "USERS:READ", want: false},
+		{permission: "", want: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.permission, func(t *testing.T) {
+			if got := IsPermission(test.permission); got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestIsReservedUserName(t *testing.T) {
+
+	tests := []struct {
+		userName string
+		want     bool
+	}{
+		{userName: InternalUser, want: true},
+		{userName: GuestUser, want: true},
+		{userName: "alice", want: false},
+		{userName: "", want: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.userName, func(t *testing.T) {
+			if got := IsReservedUserName(test.userName); got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestInternalUserHasEveryPermission(t *testing.T) {
+
+	permissions, err := GetPermissions(context.Background(), InternalUser)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, permission := range Permissions {
+		if !permissions[permission] {
+			t.Errorf("internal user is missing %v", permission)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// An API key identifying a backend service, which calls the server as the internal user
+type ServiceKey struct {
+	Name      string   `json:"name" bson:"name"`
+	Owner     string   `json:"owner" bson:"owner"`
+	KeyString string   `json:"key" bson:"-"` // Key is not stored in the database
+	KeyHash   [32]byte `json:"-" bson:"keyHash"`
+
+	// Permissions the key is restricted to, a key without scopes has every permission
+	Scopes []string `json:"scopes,omitempty" bson:"scopes,omitempty"`
+
+	Enabled   bool       `json:"enabled" bson:"enabled"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty" bson:"expiresAt,omitempty"`
+
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
+	CreatedBy string    `json:"createdBy" bson:"createdBy"`
+	UpdatedAt time.Time `json:"updatedAt" bson:"updatedAt"`
+	UpdatedBy string    `json:"updatedBy" bson:"updatedBy"`
+
+	// Hash of the key replaced by the last rotation, which stays valid until PreviousKeyExpiresAt
+	PreviousKeyHash      *[32]byte  `json:"-" bson:"previousKeyHash,omitempty"`
+	PreviousKeyExpiresAt *time.Time `json:"previousKeyExpiresAt,omitempty" bson:"previousKeyExpiresAt,omitempty"`
+	RotatedAt            *time.Time `json:"rotatedAt,omitempty" bson:"rotatedAt,omitempty"`
+}
+
+// ValidServiceKeyFilter matches the enabled and unexpired service key having the given hash, as current key or as
+// previous key still in its rotation grace period
+func ValidServiceKeyFilter(keyHash [32]byte) bson.M {
+	now := time.Now()
+	return bson.M{
+		"enabled": true,
+		"$and": []bson.M{
+			{"$or": []bson.M{
+				{"expiresAt": nil},
+				{"expiresAt": bson.M{"$gt": now}},
+			}},
+			{"$or": []bson.M{
+				{"keyHash": keyHash},
+				{"previousKeyHash": keyHash, "previousKeyExpiresAt": bson.M{"$gt": now}},
+			}},
+		},
+	}
+}
@@ -1,7 +1,11 @@
 package models
 
 import (
+	"go-graphql-mongo-server/config"
+	"go-graphql-mongo-server/logger"
 	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
 )
 
 type Token struct {
@@ -11,4 +15,49 @@ type Token struct {
 	UserName    string    `json:"userName" bson:"userName"`
 	CreatedAt   time.Time `json:"createdAt" bson:"createdAt"`
 	ExpiresAt   time.Time `json:"expiresAt" bson:"expiresAt"`
+
+	// Permissions the token is restricted to, a token without scopes has all the permissions of its user
+	Scopes []string `json:"scopes,omitempty" bson:"scopes,omitempty"`
+
+	// Usage info, written in batches by auth.FlushTokenUsage
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty" bson:"lastUsedAt,omitempty"`
+	LastUsedIP string     `json:"lastUsedIP,omitempty" bson:"lastUsedIP,omitempty"`
+	UseCount   int64      `json:"useCount" bson:"useCount"`
+
+	// Hash of the secret replaced by the last rotation, which stays valid until PreviousTokenExpiresAt
+	PreviousTokenHash      *[32]byte  `json:"-" bson:"previousTokenHash,omitempty"`
+	PreviousTokenExpiresAt *time.Time `json:"previousTokenExpiresAt,omitempty" bson:"previousTokenExpiresAt,omitempty"`
+	RotatedAt              *time.Time `json:"rotatedAt,omitempty" bson:"rotatedAt,omitempty"`
+
+	// Set when the token expires within the configured warning period, it is not stored
+	ExpiresSoon bool `json:"expiresSoon" bson:"-"`
+}
+
+// ValidTokenFilter matches the token of a user having the given hash, as current secret or as previous secret
+// still in its rotation grace period
+// The expiry is checked as well, since the TTL index only removes expired tokens periodically.
+func ValidTokenFilter(userName string, tokenHash [32]byte) bson.M {
+	now := time.Now()
+	return bson.M{
+		"userName": userName,
+		"$or": []bson.M{
+			{"tokenHash": tokenHash, "expiresAt": bson.M{"$gt": now}},
+			{"previousTokenHash": tokenHash, "previousTokenExpiresAt": bson.M{"$gt": now}},
+		},
+	}
+}
+
+// FlagExpiringTokens sets ExpiresSoon on the tokens expiring within config.Store.TokenExpiryWarning
+func FlagExpiringTokens(tokens []Token) {
+
+	warning, err := time.ParseDuration(config.Store.TokenExpiryWarning)
+	if err != nil {
+		logger.Log.Errorf("Invalid token expiry warning %v : %v", config.Store.TokenExpiryWarning, err)
+		return
+	}
+
+	warnAfter := time.Now().Add(warning)
+	for i := range tokens {
+		tokens[i].ExpiresSoon = tokens[i].ExpiresAt.Before(warnAfter)
+	}
 }
@@ -1,6 +1,13 @@
 package models
 
-import "time"
+import (
+	"context"
+	"go-graphql-mongo-server/config"
+	"go-graphql-mongo-server/logger"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
 
 type User struct {
 	ID           int       `json:"id" bson:"id"`
@@ -10,6 +17,10 @@ type User struct {
 	IsVerified   bool      `json:"isVerified" bson:"isVerified"`
 	Remarks      string    `json:"remarks" bson:"remarks"`
 	Subscription string    `json:"subscription" bson:"subscription"`
+
+	// Soft delete info, a user is deleted when DeletedAt is set
+	DeletedAt *time.Time `json:"deletedAt,omitempty" bson:"deletedAt,omitempty"`
+	DeletedBy string     `json:"deletedBy,omitempty" bson:"deletedBy,omitempty"`
 }
 
 type Address struct {
@@ -17,3 +28,60 @@ type Address struct {
 	Street string `json:"street" bson:"street"`
 	City   string `json:"city" bson:"city"`
 }
+
+// Result of a mutation on a single user
+type UserMutationResult struct {
+	ID       int   `json:"id"`
+	User     *User `json:"user"`
+	NotFound bool  `json:"notFound"`
+}
+
+type DeleteUsersResult struct {
+	Deleted     []User `json:"deleted"`
+	NotFoundIDs []int  `json:"notFoundIds"`
+}
+
+type RestoreUsersResult struct {
+	Restored    []User `json:"restored"`
+	NotFoundIDs []int  `json:"notFoundIds"`
+}
+
+// Change stream event of the users collection
+type UserChangeEvent struct {
+	OperationType     string   `json:"operationType" bson:"operationType"`
+	User              *User    `json:"user" bson:"fullDocument"`
+	ChangedFields     []string `json:"changedFields" bson:"-"`
+	UpdateDescription struct {
+		UpdatedFields bson.M   `bson:"updatedFields"`
+		RemovedFields []string `bson:"removedFields"`
+	} `json:"-" bson:"updateDescription"`
+}
+
+// ExcludeDeletedUsers restricts a users filter to the users which are not soft deleted
+func ExcludeDeletedUsers(filter bson.M) bson.M {
+	if len(filter) == 0 {
+		return bson.M{"deletedAt": nil}
+	}
+	return bson.M{"$and": []interface{}{filter, bson.M{"deletedAt": nil}}}
+}
+
+// PurgeDeletedUsers permanently deletes the users which are soft deleted for longer than the retention period
+func PurgeDeletedUsers() {
+
+	retentionPeriod, err := time.ParseDuration(config.Store.UserRetentionPeriod)
+	if err != nil || retentionPeriod <= 0 {
+		logger.Log.Errorf("Invalid user retention period %v : %v", config.Store.UserRetentionPeriod, err)
+		return
+	}
+
+	deletedCount, err := DeleteMany(
+		context.TODO(),
+		UserCollection,
+		bson.M{"deletedAt": bson.M{"$lt": time.Now().Add(-retentionPeriod)}},
+	)
+	if err != nil {
+		return
+	}
+
+	logger.Log.Infof("Purged %d soft deleted users", deletedCount)
+}
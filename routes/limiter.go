@@ -1,25 +1,46 @@
 package routes
 
 import (
+	"encoding/json"
+	"go-graphql-mongo-server/apperror"
+	"go-graphql-mongo-server/auth"
+	"go-graphql-mongo-server/common"
 	"go-graphql-mongo-server/config"
 	"go-graphql-mongo-server/logger"
+	"net/http"
 	"strconv"
 	"time"
 
+	"github.com/sethvargo/go-limiter"
 	"github.com/sethvargo/go-limiter/httplimit"
 	"github.com/sethvargo/go-limiter/memorystore"
 )
 
-var limiterMiddleware *httplimit.Middleware
+var limiterStore limiter.Store
+
+// Guests have their own, usually stricter, limit
+var guestLimiterStore limiter.Store
+
+// Clients are limited by IP, X-Forwarded-For is only used behind the trusted proxies
+func limiterKeyFunc(r *http.Request) (string, error) {
+	return common.ClientIP(r), nil
+}
 
 func createLimiterMiddleware() {
-	apiLimitPerSecond, err := strconv.ParseUint(config.Store.APILimitPerSecond, 10, 64)
+	limiterStore = newLimiterStore(config.Store.APILimitPerSecond)
+	if config.Store.GuestEnabled {
+		guestLimiterStore = newLimiterStore(config.Store.GuestAPILimitPerSecond)
+	}
+}
+
+func newLimiterStore(limitPerSecond string) limiter.Store {
+	apiLimitPerSecond, err := strconv.ParseUint(limitPerSecond, 10, 64)
 	if err != nil || apiLimitPerSecond == 0 {
-		logger.Log.Error("Error parsing apiLimitPerSecond: " + err.Error())
-		return
+		logger.Log.Errorf("Error parsing apiLimitPerSecond: %v", err)
+		return nil
 	}
 
-	limiterStore, err := memorystore.New(&memorystore.Config{
+	store, err := memorystore.New(&memorystore.Config{
 		// Number of API calls allowed per interval
 		Tokens: apiLimitPerSecond,
 
@@ -29,12 +50,60 @@ func createLimiterMiddleware() {
 
 	if err != nil {
 		logger.Log.Error("Error creating limiter store: " + err.Error())
-		return
+		return nil
 	}
+	return store
+}
 
-	limiterMiddleware, err = httplimit.NewMiddleware(limiterStore, httplimit.IPKeyFunc("X-Forwarded-For"))
-	if err != nil {
-		logger.Log.Error("Error creating limiter middleware: " + err.Error())
-		return
-	}
+// Same as httplimit.Middleware.Handle, but rejected requests get a GraphQL error with the RATE_LIMITED code
+func limiterMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		store := limiterStore
+		if auth.IsGuestRequest(r) && guestLimiterStore != nil {
+			store = guestLimiterStore
+		}
+
+		if store == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key, err := limiterKeyFunc(r)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		limit, remaining, reset, ok, err := store.Take(r.Context(), key)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		resetTime := time.Unix(0, int64(reset)).UTC().Format(time.RFC1123)
+
+		w.Header().Set(httplimit.HeaderRateLimitLimit, strconv.FormatUint(limit, 10))
+		w.Header().Set(httplimit.HeaderRateLimitRemaining, strconv.FormatUint(remaining, 10))
+		w.Header().Set(httplimit.HeaderRateLimitReset, resetTime)
+
+		if !ok {
+			w.Header().Set(httplimit.HeaderRetryAfter, resetTime)
+			writeRateLimitedError(w)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeRateLimitedError(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusTooManyRequests)
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"errors": []map[string]interface{}{{
+			"message":    "too many requests, please retry later",
+			"extensions": map[string]interface{}{"code": apperror.RateLimited},
+		}},
+	})
 }
@@ -38,7 +38,51 @@ func setAllRoutes() {
 		"/graphql",
 		gqlhandler.GraphqlHandler,
 		[]mux.MiddlewareFunc{
-			limiterMiddleware.Handle,
+			limiterMiddleware,
+			auth.Middleware,
+		})
+
+	// Only queries can be executed with GET, Eg. /api/graphql?query={...}&variables={...}&operationName=...
+	registerAPIRoute(
+		"GET",
+		"/graphql",
+		gqlhandler.GraphqlHandler,
+		[]mux.MiddlewareFunc{
+			limiterMiddleware,
+			auth.Middleware,
+		})
+
+	// Authentication is done with the connection_init message of the WebSocket protocol
+	registerAPIRoute(
+		"GET",
+		"/graphql/ws",
+		gqlhandler.SubscriptionHandler,
+		[]mux.MiddlewareFunc{
+			limiterMiddleware,
+		})
+
+	// Public keys and discovery document of the in-house tokens, so that they can be verified offline
+	registerCommonRoute(
+		"GET",
+		"/.well-known/jwks.json",
+		auth.JWKSHandler,
+		nil,
+	)
+
+	registerCommonRoute(
+		"GET",
+		"/.well-known/openid-configuration",
+		auth.DiscoveryHandler,
+		nil,
+	)
+
+	// RFC 7662 token introspection, only served when INTROSPECTION_ENABLED is true
+	registerAPIRoute(
+		"POST",
+		"/introspect",
+		auth.IntrospectionHandler,
+		[]mux.MiddlewareFunc{
+			limiterMiddleware,
 			auth.Middleware,
 		})
 
@@ -26,8 +26,9 @@ type Platform struct {
 }
 
 type User struct {
-	ID   string `json:"id"`
-	Type string `json:"type"`
+	ID         string `json:"id"`
+	Type       string `json:"type"`
+	AuthMethod string `json:"authMethod,omitempty"`
 }
 
 type Device struct {
@@ -51,7 +52,14 @@ func LogGraphQlCall(params graphql.ResolveParams, graphQlError error) {
 	name := params.Info.FieldName
 	username := common.GetUserName(params)
 
-	logger.Log.Infof("[GraphQl] %v '%v' called by %v", operation, name, username)
+	// Calls of other backend services are attributed to the service
+	if serviceName := common.GetServiceName(params); serviceName != "" {
+		username = serviceName
+	}
+
+	authMethod := common.GetAuthMethod(params)
+
+	logger.Log.Infof("[GraphQl] %v '%v' called by %v (%v)", operation, name, username, authMethod)
 	if graphQlError != nil {
 		logger.Log.Errorf("[GraphQl] %v '%v' error: %v", operation, name, graphQlError)
 	}
@@ -71,8 +79,9 @@ func LogGraphQlCall(params graphql.ResolveParams, graphQlError error) {
 			ComponentName: config.Store.ComponentName,
 		},
 		User: User{
-			ID:   username,
-			Type: common.GetUserType(params),
+			ID:         username,
+			Type:       common.GetUserType(params),
+			AuthMethod: authMethod,
 		},
 		Device: Device{
 			DeviceType: "Server",